@@ -0,0 +1,261 @@
+/*
+Copyright © 2021 Betsson Group AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/BetssonGroup/fluenthose/pkg/firehose"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// envPrefix prefixes the environment variables overriding configuration
+// keys, e.g. FLUENTHOSE_WAL_DIR for wal-dir.
+const envPrefix = "FLUENTHOSE"
+
+var (
+	// configFile is the YAML or TOML configuration file set with --config.
+	configFile string
+	// config holds the configuration file and environment overrides
+	// loaded by loadConfig.
+	config = viper.New()
+)
+
+// loadConfig loads the configuration of cmd. Every flag is a key of the
+// configuration file under its long name, and can be overridden with an
+// environment variable. Flags set on the command line take precedence over
+// environment variables, which take precedence over the configuration
+// file. It can be called again to reload the configuration, flags whose
+// key was removed are reset to their default.
+func loadConfig(cmd *cobra.Command) error {
+	config = viper.New()
+	config.SetEnvPrefix(envPrefix)
+	config.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	config.AutomaticEnv()
+	if configFile != "" {
+		config.SetConfigFile(configFile)
+		if err := config.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file %s: %w", configFile, err)
+		}
+	}
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "config" {
+			return
+		}
+		if !config.IsSet(f.Name) {
+			err = resetFlag(f)
+			return
+		}
+		err = setFlag(f)
+	})
+	return err
+}
+
+// setFlag sets the value of f from the configuration. Lists of the
+// configuration file replace slice flags, strings are parsed like the
+// flag, so environment variables take comma separated lists.
+func setFlag(f *pflag.Flag) error {
+	var err error
+	slice, isSlice := f.Value.(pflag.SliceValue)
+	if list, ok := config.Get(f.Name).([]interface{}); ok {
+		values := make([]string, len(list))
+		for i, v := range list {
+			values[i] = fmt.Sprint(v)
+		}
+		if isSlice {
+			err = slice.Replace(values)
+		} else {
+			err = fmt.Errorf("expected a single value")
+		}
+	} else if isSlice {
+		// Set appends to slices that were set before, a reload has to
+		// replace them
+		var values []string
+		if values, err = splitList(config.GetString(f.Name)); err == nil {
+			err = slice.Replace(values)
+		}
+	} else {
+		err = f.Value.Set(config.GetString(f.Name))
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+// resetFlag sets f back to its default value.
+func resetFlag(f *pflag.Flag) error {
+	if f.Value.String() == f.DefValue {
+		return nil
+	}
+	if slice, ok := f.Value.(pflag.SliceValue); ok {
+		// slice defaults are formatted as [a,b]
+		values, err := splitList(strings.Trim(f.DefValue, "[]"))
+		if err != nil {
+			return err
+		}
+		return slice.Replace(values)
+	}
+	return f.Value.Set(f.DefValue)
+}
+
+// splitList parses a comma separated list like slice flags do.
+func splitList(s string) ([]string, error) {
+	values, err := csv.NewReader(strings.NewReader(s)).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return values, err
+}
+
+// secret returns the secret configured under key. Secrets are not flags,
+// they are read from the file set as key-file, which is watched for
+// changes, from key in the configuration file or its FLUENTHOSE_*
+// environment variable, or from legacyEnv.
+func secret(key, legacyEnv string) (string, error) {
+	if file := config.GetString(key + "-file"); file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	if value := config.GetString(key); value != "" {
+		return value, nil
+	}
+	return os.Getenv(legacyEnv), nil
+}
+
+// secretFiles returns the files secrets are read from.
+func secretFiles() []string {
+	var files []string
+	for _, key := range []string{"access-key", "forward-shared-key", "forward-password"} {
+		if file := config.GetString(key + "-file"); file != "" {
+			files = append(files, file)
+		}
+	}
+	entries, _ := accessKeyEntries()
+	for _, entry := range entries {
+		if entry.KeyFile != "" {
+			files = append(files, entry.KeyFile)
+		}
+	}
+	return files
+}
+
+// accessKeyEntry is an entry of the access-keys list of the configuration
+// file.
+type accessKeyEntry struct {
+	Name       string    `mapstructure:"name"`
+	Key        string    `mapstructure:"key"`
+	KeyFile    string    `mapstructure:"key-file"`
+	NotBefore  time.Time `mapstructure:"not-before"`
+	NotAfter   time.Time `mapstructure:"not-after"`
+	EventTypes []string  `mapstructure:"event-types"`
+	Tenant     string    `mapstructure:"tenant"`
+}
+
+func accessKeyEntries() ([]accessKeyEntry, error) {
+	var entries []accessKeyEntry
+	err := config.UnmarshalKey("access-keys", &entries, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid access-keys: %w", err)
+	}
+	return entries, nil
+}
+
+// accessKeys returns the keys of the access-keys list of the configuration
+// file, and the access-key secret as the key named default.
+func accessKeys() ([]firehose.AccessKey, error) {
+	var keys []firehose.AccessKey
+	key, err := secret("access-key", "ACCESS_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		keys = append(keys, firehose.AccessKey{Name: "default", Key: key})
+	}
+	entries, err := accessKeyEntries()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		key := entry.Key
+		if entry.KeyFile != "" {
+			data, err := ioutil.ReadFile(entry.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read access key %s: %w", entry.Name, err)
+			}
+			key = strings.TrimSpace(string(data))
+		}
+		keys = append(keys, firehose.AccessKey{
+			Name:       entry.Name,
+			Key:        key,
+			NotBefore:  entry.NotBefore,
+			NotAfter:   entry.NotAfter,
+			EventTypes: entry.EventTypes,
+			Tenant:     entry.Tenant,
+		})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("an access key is required, set access-keys or access-key in the config file or the FLUENTHOSE_ACCESS_KEY environment variable")
+	}
+	return keys, nil
+}
+
+// tenantEntry is an entry of the tenants list of the configuration file.
+type tenantEntry struct {
+	Name              string   `mapstructure:"name"`
+	TagPrefix         string   `mapstructure:"tag-prefix"`
+	Forward           []string `mapstructure:"forward"`
+	EventTypes        []string `mapstructure:"event-types"`
+	RequestsPerSecond float64  `mapstructure:"requests-per-second"`
+	Burst             int      `mapstructure:"burst"`
+}
+
+// tenants returns the tenants list of the configuration file.
+func tenants() ([]firehose.Tenant, error) {
+	var entries []tenantEntry
+	err := config.UnmarshalKey("tenants", &entries, viper.DecodeHook(mapstructure.StringToSliceHookFunc(",")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenants: %w", err)
+	}
+	tenants := make([]firehose.Tenant, len(entries))
+	for i, entry := range entries {
+		tenants[i] = firehose.Tenant{
+			Name:              entry.Name,
+			TagPrefix:         entry.TagPrefix,
+			ForwardAddresses:  entry.Forward,
+			EventTypes:        entry.EventTypes,
+			RequestsPerSecond: entry.RequestsPerSecond,
+			Burst:             entry.Burst,
+		}
+	}
+	return tenants, nil
+}
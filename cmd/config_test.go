@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/spf13/cobra"
+)
+
+// testCommand returns a command with flags like the ones of serve.
+func testCommand() *cobra.Command {
+	cmd := &cobra.Command{Use: "test"}
+	cmd.Flags().String("log-level", "info", "")
+	cmd.Flags().Int("batch-max-entries", 1, "")
+	cmd.Flags().StringSlice("forward", []string{"127.0.0.1:24224", "127.0.0.1:24225"}, "")
+	return cmd
+}
+
+func TestLoadConfig(t *testing.T) {
+	Convey("Given a configuration file", t, func() {
+		file := filepath.Join(t.TempDir(), "fluenthose.yaml")
+		writeConfig := func(content string) {
+			So(ioutil.WriteFile(file, []byte(content), 0600), ShouldBeNil)
+		}
+		writeConfig("log-level: debug\nbatch-max-entries: 100\nforward:\n  - fluentd-0:24224\n")
+		configFile = file
+		Reset(func() {
+			configFile = ""
+		})
+		cmd := testCommand()
+		flags := cmd.Flags()
+		setenv := func(key, value string) {
+			So(os.Setenv(key, value), ShouldBeNil)
+			Reset(func() {
+				os.Unsetenv(key)
+			})
+		}
+
+		Convey("Keys of the file should override the defaults", func() {
+			So(loadConfig(cmd), ShouldBeNil)
+			So(flags.Lookup("log-level").Value.String(), ShouldEqual, "debug")
+			So(flags.Lookup("batch-max-entries").Value.String(), ShouldEqual, "100")
+			forward, err := flags.GetStringSlice("forward")
+			So(err, ShouldBeNil)
+			So(forward, ShouldResemble, []string{"fluentd-0:24224"})
+		})
+
+		Convey("Environment variables should override the file", func() {
+			setenv("FLUENTHOSE_LOG_LEVEL", "warn")
+			setenv("FLUENTHOSE_FORWARD", "fluentd-1:24224,fluentd-2:24224")
+			So(loadConfig(cmd), ShouldBeNil)
+			So(flags.Lookup("log-level").Value.String(), ShouldEqual, "warn")
+			forward, err := flags.GetStringSlice("forward")
+			So(err, ShouldBeNil)
+			So(forward, ShouldResemble, []string{"fluentd-1:24224", "fluentd-2:24224"})
+		})
+
+		Convey("Lists of environment variables should be replaced on reload", func() {
+			setenv("FLUENTHOSE_FORWARD", "fluentd-1:24224,fluentd-2:24224")
+			for i := 0; i < 3; i++ {
+				So(loadConfig(cmd), ShouldBeNil)
+			}
+			forward, err := flags.GetStringSlice("forward")
+			So(err, ShouldBeNil)
+			So(forward, ShouldResemble, []string{"fluentd-1:24224", "fluentd-2:24224"})
+		})
+
+		Convey("Lists of scalar keys should be replaced on reload", func() {
+			writeConfig("forward: fluentd-0:24224\n")
+			for i := 0; i < 3; i++ {
+				So(loadConfig(cmd), ShouldBeNil)
+			}
+			forward, err := flags.GetStringSlice("forward")
+			So(err, ShouldBeNil)
+			So(forward, ShouldResemble, []string{"fluentd-0:24224"})
+		})
+
+		Convey("Flags should override environment variables", func() {
+			setenv("FLUENTHOSE_LOG_LEVEL", "warn")
+			So(flags.Set("log-level", "error"), ShouldBeNil)
+			So(loadConfig(cmd), ShouldBeNil)
+			So(flags.Lookup("log-level").Value.String(), ShouldEqual, "error")
+		})
+
+		Convey("Invalid values should be rejected", func() {
+			writeConfig("batch-max-entries: many\n")
+			So(loadConfig(cmd), ShouldNotBeNil)
+			writeConfig("log-level:\n  - debug\n")
+			So(loadConfig(cmd), ShouldNotBeNil)
+		})
+
+		Convey("A missing file should be reported", func() {
+			configFile = filepath.Join(t.TempDir(), "missing.yaml")
+			So(loadConfig(cmd), ShouldNotBeNil)
+		})
+
+		Convey("When a key is removed and the configuration is reloaded", func() {
+			So(loadConfig(cmd), ShouldBeNil)
+			writeConfig("batch-max-entries: 100\n")
+			So(loadConfig(cmd), ShouldBeNil)
+
+			Convey("Then its flag should be reset to the default", func() {
+				So(flags.Lookup("log-level").Value.String(), ShouldEqual, "info")
+				So(flags.Lookup("batch-max-entries").Value.String(), ShouldEqual, "100")
+				forward, err := flags.GetStringSlice("forward")
+				So(err, ShouldBeNil)
+				So(forward, ShouldResemble, []string{"127.0.0.1:24224", "127.0.0.1:24225"})
+			})
+		})
+	})
+
+	Convey("Without a configuration file the defaults should be kept", t, func() {
+		cmd := testCommand()
+		So(loadConfig(cmd), ShouldBeNil)
+		So(cmd.Flags().Lookup("log-level").Value.String(), ShouldEqual, "info")
+		forward, err := cmd.Flags().GetStringSlice("forward")
+		So(err, ShouldBeNil)
+		So(forward, ShouldResemble, []string{"127.0.0.1:24224", "127.0.0.1:24225"})
+	})
+}
@@ -0,0 +1,137 @@
+/*
+Copyright © 2021 Betsson Group AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"compress/gzip"
+	"os"
+	"time"
+
+	"github.com/BetssonGroup/fluenthose/pkg/cloudfront"
+	"github.com/BetssonGroup/fluenthose/pkg/firehose"
+	"github.com/spf13/cobra"
+)
+
+// addForwardFlags adds the flags configuring the forward output to cmd.
+func addForwardFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceP("forward", "f", []string{"127.0.0.1:24224"}, "Forward addresses, host:port or tcp://host:port, tls://host:port for TLS, unix:///path for a Unix socket (repeat or separate with commas for multiple upstreams)")
+	// Balance messages over multiple upstreams
+	cmd.Flags().String("upstream-strategy", firehose.StrategyRoundRobin, "How to choose between multiple upstreams: round-robin, least-inflight or failover")
+	cmd.Flags().Duration("health-check-interval", 10*time.Second, "How often upstreams are dialed to update their health (0 disables)")
+	// Set the TLS settings for tls:// forward addresses
+	cmd.Flags().String("forward-tls-ca-file", "", "PEM bundle of the CAs to verify the forwarder with (system roots if empty)")
+	cmd.Flags().String("forward-tls-cert-file", "", "Client certificate presented to the forwarder")
+	cmd.Flags().String("forward-tls-key-file", "", "Key of the client certificate")
+	cmd.Flags().String("forward-tls-server-name", "", "Name to verify the forwarder certificate against (host of --forward if empty)")
+	cmd.Flags().Bool("forward-tls-insecure-skip-verify", false, "Do not verify the forwarder certificate (for tests only)")
+	// Authenticate to the forwarder, the shared key and password are the
+	// forward-shared-key and forward-password secrets
+	cmd.Flags().String("forward-username", "", "Username for forwarders requiring user authentication")
+	cmd.Flags().String("forward-hostname", hostname(), "Client hostname sent in the forward handshake")
+	// Reconnect to the forwarder with exponential backoff
+	cmd.Flags().Duration("reconnect-backoff", 100*time.Millisecond, "Delay before reconnecting to the forwarder, doubled after every failed attempt")
+	cmd.Flags().Duration("reconnect-max-backoff", 30*time.Second, "Maximum delay between reconnection attempts")
+	// Wait for the forwarder to acknowledge every message
+	cmd.Flags().Bool("require-ack", false, "Wait for the forwarder to acknowledge messages before answering Firehose")
+	cmd.Flags().Duration("ack-timeout", 5*time.Second, "How long to wait for an acknowledgement from the forwarder")
+	// Pack the records of a request into PackedForward messages
+	cmd.Flags().Int("batch-max-entries", 1000, "Maximum number of entries per PackedForward message (1 sends messages one by one)")
+	cmd.Flags().Int("batch-max-bytes", 256*1024, "Maximum size in bytes of the event stream of a PackedForward message")
+	// Send gzip compressed PackedForward messages
+	cmd.Flags().Bool("compress", false, "Send gzip compressed PackedForward messages (requires batching)")
+	cmd.Flags().Int("compression-level", gzip.DefaultCompression, "Gzip compression level, from 1 (fastest) to 9 (best)")
+}
+
+// forwardOptions returns the forward addresses and options set by the
+// flags of addForwardFlags.
+func forwardOptions(cmd *cobra.Command) ([]string, firehose.ForwardOptions, error) {
+	sharedKey, err := secret("forward-shared-key", "FORWARD_SHARED_KEY")
+	if err != nil {
+		return nil, firehose.ForwardOptions{}, err
+	}
+	password, err := secret("forward-password", "FORWARD_PASSWORD")
+	if err != nil {
+		return nil, firehose.ForwardOptions{}, err
+	}
+	forwardAddresses, _ := cmd.Flags().GetStringSlice("forward")
+	requireAck, _ := cmd.Flags().GetBool("require-ack")
+	ackTimeout, _ := cmd.Flags().GetDuration("ack-timeout")
+	batchMaxEntries, _ := cmd.Flags().GetInt("batch-max-entries")
+	batchMaxBytes, _ := cmd.Flags().GetInt("batch-max-bytes")
+	compress, _ := cmd.Flags().GetBool("compress")
+	compressionLevel, _ := cmd.Flags().GetInt("compression-level")
+	reconnectBackoff, _ := cmd.Flags().GetDuration("reconnect-backoff")
+	reconnectMaxBackoff, _ := cmd.Flags().GetDuration("reconnect-max-backoff")
+	healthCheckInterval, _ := cmd.Flags().GetDuration("health-check-interval")
+	tlsInsecureSkipVerify, _ := cmd.Flags().GetBool("forward-tls-insecure-skip-verify")
+	return forwardAddresses, firehose.ForwardOptions{
+		RequireAck:          requireAck,
+		AckTimeout:          ackTimeout,
+		BatchMaxEntries:     batchMaxEntries,
+		BatchMaxBytes:       batchMaxBytes,
+		Compress:            compress,
+		CompressionLevel:    compressionLevel,
+		ReconnectBackoff:    reconnectBackoff,
+		ReconnectMaxBackoff: reconnectMaxBackoff,
+		Strategy:            cmd.Flag("upstream-strategy").Value.String(),
+		HealthCheckInterval: healthCheckInterval,
+		TLS: firehose.TLSOptions{
+			CAFile:             cmd.Flag("forward-tls-ca-file").Value.String(),
+			CertFile:           cmd.Flag("forward-tls-cert-file").Value.String(),
+			KeyFile:            cmd.Flag("forward-tls-key-file").Value.String(),
+			ServerName:         cmd.Flag("forward-tls-server-name").Value.String(),
+			InsecureSkipVerify: tlsInsecureSkipVerify,
+		},
+		SharedKey: sharedKey,
+		Username:  cmd.Flag("forward-username").Value.String(),
+		Password:  password,
+		Hostname:  cmd.Flag("forward-hostname").Value.String(),
+	}, nil
+}
+
+// addDecoderFlags adds the flags configuring the decoders to cmd.
+func addDecoderFlags(cmd *cobra.Command) {
+	// Set the decoder used for event types without a registered decoder
+	cmd.Flags().String("fallback-event-type", "", "Decoder used for unknown event types, e.g. raw (unknown types are dropped if empty)")
+	// Set the timestamp format of forwarded messages
+	cmd.Flags().String("time-format", firehose.TimeFormatEventTime, "Timestamp format of forwarded messages: eventtime or seconds (for receivers without EventTime support)")
+	// Set the field order of the CloudFront real-time log configuration
+	cmd.Flags().StringSlice("cloudfront-fields", cloudfront.DefaultFields, "Fields of the CloudFront real-time log configuration, in order")
+}
+
+// setupDecoders configures the decoders from the flags of addDecoderFlags.
+func setupDecoders(cmd *cobra.Command) {
+	if err := firehose.SetFallbackDecoder(cmd.Flag("fallback-event-type").Value.String()); err != nil {
+		cobra.CheckErr(err)
+	}
+	cloudfrontFields, _ := cmd.Flags().GetStringSlice("cloudfront-fields")
+	if err := firehose.SetCloudfrontFields(cloudfrontFields); err != nil {
+		cobra.CheckErr(err)
+	}
+	if err := firehose.SetTimeFormat(cmd.Flag("time-format").Value.String()); err != nil {
+		cobra.CheckErr(err)
+	}
+}
+
+// hostname returns the hostname of the machine, or localhost if it is
+// unknown.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "localhost"
+	}
+	return name
+}
@@ -0,0 +1,180 @@
+/*
+Copyright © 2021 Betsson Group AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/BetssonGroup/fluenthose/pkg/firehose"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// reloadDelay collects the file events of an update into one reload.
+const reloadDelay = time.Second
+
+// restartFlags are the flags of serve that are only applied on start,
+// besides the ones of firehose.Config that Reload keeps.
+var restartFlags = []string{
+	"listen",
+	"log-level",
+	"fallback-event-type",
+	"time-format",
+	"cloudfront-fields",
+	"dlq-dir",
+	"dedupe-ttl",
+	"dedupe-max-entries",
+	"dedupe-file",
+	"max-body-bytes",
+	"max-decoded-body-bytes",
+}
+
+// watchConfig reloads the configuration of cmd on SIGHUP and when the
+// configuration file, a secret file or a TLS file changes.
+func watchConfig(cmd *cobra.Command) {
+	reload := make(chan struct{}, 1)
+	trigger := func() {
+		select {
+		case reload <- struct{}{}:
+		default:
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			log.Infof("received SIGHUP, reloading configuration")
+			trigger()
+		}
+	}()
+	watchFiles(configFiles(cmd), trigger)
+
+	started := flagValues(cmd, restartFlags)
+	go func() {
+		for range reload {
+			err := firehose.Reload(func() (firehose.Config, error) {
+				if err := loadConfig(cmd); err != nil {
+					return firehose.Config{}, err
+				}
+				for _, name := range changedFlags(cmd, started) {
+					log.Warnf("%s is only applied on restart", name)
+				}
+				return serverConfig(cmd)
+			})
+			if err != nil {
+				log.Errorf("failed to reload configuration: %s", err)
+				continue
+			}
+			log.Infof("configuration reloaded")
+		}
+	}()
+}
+
+// flagValues returns the values of the flags of cmd named names.
+func flagValues(cmd *cobra.Command, names []string) map[string]string {
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		if f := cmd.Flag(name); f != nil {
+			values[name] = f.Value.String()
+		}
+	}
+	return values
+}
+
+// changedFlags returns the names of the flags of cmd whose value differs
+// from values.
+func changedFlags(cmd *cobra.Command, values map[string]string) []string {
+	var changed []string
+	for name, value := range values {
+		if cmd.Flag(name).Value.String() != value {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// configFiles returns the configuration file, the secret files and the
+// TLS files of cmd.
+func configFiles(cmd *cobra.Command) []string {
+	var files []string
+	if configFile != "" {
+		files = append(files, configFile)
+	}
+	for _, name := range []string{"tls-cert-file", "tls-key-file", "tls-client-ca-file"} {
+		if f := cmd.Flags().Lookup(name); f != nil && f.Value.String() != "" {
+			files = append(files, f.Value.String())
+		}
+	}
+	return append(files, secretFiles()...)
+}
+
+// watchFiles calls changed once the files stop changing for reloadDelay.
+// The directories of the files are watched rather than the files, which
+// are replaced rather than written to by editors and Kubernetes volume
+// updates.
+func watchFiles(files []string, changed func()) {
+	if len(files) == 0 {
+		return
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Errorf("failed to watch configuration files, reload with SIGHUP: %s", err)
+		return
+	}
+	names := make(map[string]bool)
+	for _, file := range files {
+		names[filepath.Base(file)] = true
+		dir := filepath.Dir(file)
+		if err := watcher.Add(dir); err != nil {
+			log.Errorf("failed to watch %s, reload with SIGHUP: %s", dir, err)
+		}
+	}
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Kubernetes swaps the ..data symlink of a volume
+				name := filepath.Base(event.Name)
+				if !names[name] && !strings.HasPrefix(name, "..") {
+					continue
+				}
+				if timer == nil {
+					timer = time.AfterFunc(reloadDelay, changed)
+				} else {
+					timer.Reset(reloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("error watching configuration files: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestChangedFlags(t *testing.T) {
+	Convey("Given the values of flags on start", t, func() {
+		cmd := testCommand()
+		started := flagValues(cmd, []string{"log-level", "forward", "missing"})
+		So(started, ShouldResemble, map[string]string{
+			"log-level": "info",
+			"forward":   "[127.0.0.1:24224,127.0.0.1:24225]",
+		})
+
+		Convey("When nothing changed", func() {
+			So(changedFlags(cmd, started), ShouldBeEmpty)
+		})
+
+		Convey("When flags changed", func() {
+			So(cmd.Flags().Set("forward", "fluentd-0:24224"), ShouldBeNil)
+			So(cmd.Flags().Set("log-level", "debug"), ShouldBeNil)
+			So(cmd.Flags().Set("batch-max-entries", "100"), ShouldBeNil)
+			Convey("Then only those of the start values should be reported", func() {
+				So(changedFlags(cmd, started), ShouldResemble, []string{"forward", "log-level"})
+			})
+		})
+	})
+}
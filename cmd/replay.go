@@ -0,0 +1,83 @@
+/*
+Copyright © 2021 Betsson Group AB
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"github.com/BetssonGroup/fluenthose/pkg/firehose"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Decode and forward the records in the dead-letter directory again",
+	Long: `Replay runs the records written to the dead-letter directory by serve
+through the current decoders and forwards them, records of access keys
+belonging to a tenant to its upstreams with its tag prefix. Forwarded
+records are deleted, records that still fail to decode are kept.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		setupLogging()
+		// checked here rather than marked required, it may be set in
+		// the config file
+		dlqDir := cmd.Flag("dlq-dir").Value.String()
+		if dlqDir == "" {
+			cobra.CheckErr("dlq-dir is required")
+		}
+		setupDecoders(cmd)
+		config, err := replayConfig(cmd)
+		cobra.CheckErr(err)
+		replayed, failed, err := firehose.ReplayDeadLetters(dlqDir, config)
+		log.Infof("replayed %d dead letters, %d still failing", replayed, failed)
+		cobra.CheckErr(err)
+	},
+}
+
+// replayConfig returns the upstreams and tenants dead letters are replayed
+// to. Access keys only route records to their tenant, so their secrets are
+// not read.
+func replayConfig(cmd *cobra.Command) (firehose.Config, error) {
+	forwardAddresses, forwardOpts, err := forwardOptions(cmd)
+	if err != nil {
+		return firehose.Config{}, err
+	}
+	entries, err := accessKeyEntries()
+	if err != nil {
+		return firehose.Config{}, err
+	}
+	keys := make([]firehose.AccessKey, len(entries))
+	for i, entry := range entries {
+		keys[i] = firehose.AccessKey{Name: entry.Name, Tenant: entry.Tenant}
+	}
+	tenants, err := tenants()
+	if err != nil {
+		return firehose.Config{}, err
+	}
+	return firehose.Config{
+		AccessKeys:       keys,
+		ForwardAddresses: forwardAddresses,
+		Forward:          forwardOpts,
+		Tenants:          tenants,
+	}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().String("dlq-dir", "", "Directory of the undecodable records written by serve")
+	addForwardFlags(replayCmd)
+	addDecoderFlags(replayCmd)
+}
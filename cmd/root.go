@@ -24,6 +24,9 @@ import (
 var rootCmd = &cobra.Command{
 	Use:   "fluenthose",
 	Short: "Receive Kinesis Data Firehose events over HTTP and forward to FluentBit",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		cobra.CheckErr(loadConfig(cmd))
+	},
 }
 
 func Execute() {
@@ -33,6 +36,7 @@ func Execute() {
 func init() {
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	rootCmd.PersistentFlags().StringP("log-level", "", "info", "Log level")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Configuration file in YAML or TOML, overridden by FLUENTHOSE_* environment variables and flags")
 
 }
 
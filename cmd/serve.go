@@ -16,7 +16,7 @@ limitations under the License.
 package cmd
 
 import (
-	"os"
+	"time"
 
 	"github.com/BetssonGroup/fluenthose/pkg/firehose"
 	log "github.com/sirupsen/logrus"
@@ -29,20 +29,73 @@ var serveCmd = &cobra.Command{
 	Short: "Serve a HTTP endpoint for Kineses Data Firehose",
 	Run: func(cmd *cobra.Command, args []string) {
 		setupLogging()
-		accessKey := os.Getenv("ACCESS_KEY")
-		if accessKey == "" {
-			cobra.CheckErr("ACCESS_KEY environment variable is required")
+		log.Infof("log-level: %s", log.GetLevel())
+		setupDecoders(cmd)
+		if err := firehose.SetDeadLetterDir(cmd.Flag("dlq-dir").Value.String()); err != nil {
+			cobra.CheckErr(err)
 		}
-		log.Info("log-level: %s", log.GetLevel())
-		firehose.RunFirehoseServer(
-			cmd.Flag("listen").Value.String(),
-			accessKey,
-			cmd.Flag("forward").Value.String(),
-			cmd.Flag("event-type-header-name").Value.String(),
-		)
+		dedupeTTL, _ := cmd.Flags().GetDuration("dedupe-ttl")
+		dedupeMaxEntries, _ := cmd.Flags().GetInt("dedupe-max-entries")
+		if err := firehose.SetRequestDedupe(dedupeTTL, dedupeMaxEntries, cmd.Flag("dedupe-file").Value.String()); err != nil {
+			cobra.CheckErr(err)
+		}
+		maxBodyBytes, _ := cmd.Flags().GetInt64("max-body-bytes")
+		firehose.SetMaxRequestBodyBytes(maxBodyBytes)
+		maxDecodedBodyBytes, _ := cmd.Flags().GetInt64("max-decoded-body-bytes")
+		firehose.SetMaxDecodedBodyBytes(maxDecodedBodyBytes)
+		config, err := serverConfig(cmd)
+		cobra.CheckErr(err)
+		watchConfig(cmd)
+		firehose.RunFirehoseServer(cmd.Flag("listen").Value.String(), config)
 	},
 }
 
+// serverConfig returns the reloadable configuration of the server set by
+// the flags of serveCmd and the secrets.
+func serverConfig(cmd *cobra.Command) (firehose.Config, error) {
+	keys, err := accessKeys()
+	if err != nil {
+		return firehose.Config{}, err
+	}
+	tenants, err := tenants()
+	if err != nil {
+		return firehose.Config{}, err
+	}
+	forwardAddresses, forwardOpts, err := forwardOptions(cmd)
+	if err != nil {
+		return firehose.Config{}, err
+	}
+	allowedCIDRs, _ := cmd.Flags().GetStringSlice("allowed-cidrs")
+	trustedProxies, _ := cmd.Flags().GetStringSlice("trusted-proxies")
+	proxyProtocol, _ := cmd.Flags().GetBool("proxy-protocol")
+	tlsCipherSuites, _ := cmd.Flags().GetStringSlice("tls-cipher-suites")
+	walMaxBytes, _ := cmd.Flags().GetInt64("wal-max-bytes")
+	walSegmentBytes, _ := cmd.Flags().GetInt64("wal-segment-bytes")
+	forwardOpts.WAL = firehose.WALOptions{
+		Dir:          cmd.Flag("wal-dir").Value.String(),
+		MaxBytes:     walMaxBytes,
+		SegmentBytes: walSegmentBytes,
+		Overflow:     cmd.Flag("wal-overflow").Value.String(),
+	}
+	return firehose.Config{
+		AccessKeys:       keys,
+		EventTypeHeader:  cmd.Flag("event-type-header-name").Value.String(),
+		ForwardAddresses: forwardAddresses,
+		Forward:          forwardOpts,
+		Tenants:          tenants,
+		AllowedCIDRs:     allowedCIDRs,
+		TrustedProxies:   trustedProxies,
+		ProxyProtocol:    proxyProtocol,
+		TLS: firehose.ServerTLSOptions{
+			CertFile:     cmd.Flag("tls-cert-file").Value.String(),
+			KeyFile:      cmd.Flag("tls-key-file").Value.String(),
+			MinVersion:   cmd.Flag("tls-min-version").Value.String(),
+			CipherSuites: tlsCipherSuites,
+			ClientCAFile: cmd.Flag("tls-client-ca-file").Value.String(),
+		},
+	}, nil
+}
+
 func init() {
 	rootCmd.AddCommand(serveCmd)
 
@@ -56,7 +109,33 @@ func init() {
 	// is called directly, e.g.:
 	// serveCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 	serveCmd.Flags().StringP("listen", "l", ":8080", "Listen address")
-	serveCmd.Flags().StringP("forward", "f", "127.0.0.1:24224", "Forward address")
+	// Serve the endpoint with TLS, the files are reloaded when they change
+	serveCmd.Flags().String("tls-cert-file", "", "Certificate chain to serve the endpoint with TLS (plain HTTP if empty)")
+	serveCmd.Flags().String("tls-key-file", "", "Key of the TLS certificate")
+	serveCmd.Flags().String("tls-min-version", "1.2", "Minimum TLS version accepted: 1.2 or 1.3")
+	serveCmd.Flags().StringSlice("tls-cipher-suites", nil, "Cipher suites accepted with TLS 1.2, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (Go defaults if empty)")
+	serveCmd.Flags().String("tls-client-ca-file", "", "PEM bundle of the CAs the client certificates of Firehose requests must be signed by (client certificates are not required if empty)")
+	// Only accept requests from the Firehose address ranges
+	serveCmd.Flags().StringSlice("allowed-cidrs", nil, "CIDRs requests are accepted from, e.g. the Firehose ranges of the region (everywhere if empty)")
+	serveCmd.Flags().StringSlice("trusted-proxies", nil, "CIDRs of load balancers whose X-Forwarded-For header determines the source address")
+	// Read the client address from PROXY protocol headers of load balancers
+	serveCmd.Flags().Bool("proxy-protocol", false, "Accept PROXY protocol v1 and v2 headers from --trusted-proxies (from all sources if empty, which --allowed-cidrs does not allow)")
+	// Limit the size of request bodies after gzip decoding
+	serveCmd.Flags().Int64("max-body-bytes", 64*1024*1024, "Maximum size of a request body as sent on the wire")
+	serveCmd.Flags().Int64("max-decoded-body-bytes", 64*1024*1024, "Maximum size of a request body after Content-Encoding decoding")
+	addForwardFlags(serveCmd)
+	// Buffer records on disk before forwarding them
+	serveCmd.Flags().String("wal-dir", "", "Directory of the write-ahead log, records are acknowledged once written to it (disabled if empty)")
+	serveCmd.Flags().Int64("wal-max-bytes", 1024*1024*1024, "Maximum size of the write-ahead log of each tenant on disk (0 for unlimited)")
+	serveCmd.Flags().Int64("wal-segment-bytes", 64*1024*1024, "Size at which the write-ahead log starts a new segment file")
+	serveCmd.Flags().String("wal-overflow", firehose.OverflowReject, "What to do when the write-ahead log is full: reject (answer Firehose with 503) or drop-oldest")
 	// Set event type header name
 	serveCmd.Flags().StringP("event-type-header-name", "e", "X-EVENT-TYPE", "Event type header name")
+	addDecoderFlags(serveCmd)
+	// Keep records that fail to decode for a later replay
+	serveCmd.Flags().String("dlq-dir", "", "Directory undecodable records are written to (only logged if empty)")
+	// Acknowledge retries of delivered requests without forwarding them again
+	serveCmd.Flags().Duration("dedupe-ttl", 2*time.Hour, "How long delivered request IDs are remembered to detect Firehose retries (0 disables)")
+	serveCmd.Flags().Int("dedupe-max-entries", 100000, "Maximum number of remembered request IDs")
+	serveCmd.Flags().String("dedupe-file", "", "File the remembered request IDs are persisted to across restarts (memory only if empty)")
 }
@@ -0,0 +1,294 @@
+// Package cloudfront parses CloudFront real-time log lines.
+package cloudfront
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// DefaultFields is the field order of a real-time log configuration with all
+// fields selected, as documented in
+// https://docs.aws.amazon.com/AmazonCloudFront/latest/DeveloperGuide/real-time-logs.html#understand-real-time-log-config-fields
+var DefaultFields = []string{
+	"timestamp",
+	"c-ip",
+	"time-to-first-byte",
+	"sc-status",
+	"sc-bytes",
+	"cs-method",
+	"cs-protocol",
+	"cs-host",
+	"cs-uri-stem",
+	"cs-bytes",
+	"x-edge-location",
+	"x-edge-request-id",
+	"x-host-header",
+	"time-taken",
+	"cs-protocol-version",
+	"c-ip-version",
+	"cs-user-agent",
+	"cs-referer",
+	"cs-cookie",
+	"cs-uri-query",
+	"x-edge-response-result-type",
+	"x-forwarded-for",
+	"ssl-protocol",
+	"ssl-cipher",
+	"x-edge-result-type",
+	"fle-encrypted-fields",
+	"fle-status",
+	"sc-content-type",
+	"sc-content-len",
+	"sc-range-start",
+	"sc-range-end",
+	"c-port",
+	"x-edge-detailed-result-type",
+	"c-country",
+	"cs-accept-encoding",
+	"cs-accept",
+	"cache-behavior-path-pattern",
+	"cs-headers",
+	"cs-header-names",
+	"cs-headers-count",
+}
+
+// fieldKind describes how the value of a field is converted.
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindInt
+	kindFloat
+	kindTime
+	kindEscaped
+	kindHeaders
+	kindCookies
+	kindHost
+)
+
+// knownFields maps every field CloudFront can emit to its kind.
+var knownFields = map[string]fieldKind{
+	"timestamp":                     kindTime,
+	"c-ip":                          kindString,
+	"time-to-first-byte":            kindFloat,
+	"sc-status":                     kindInt,
+	"sc-bytes":                      kindInt,
+	"cs-method":                     kindString,
+	"cs-protocol":                   kindString,
+	"cs-host":                       kindHost,
+	"cs-uri-stem":                   kindString,
+	"cs-bytes":                      kindInt,
+	"x-edge-location":               kindString,
+	"x-edge-request-id":             kindString,
+	"x-host-header":                 kindString,
+	"time-taken":                    kindFloat,
+	"cs-protocol-version":           kindString,
+	"c-ip-version":                  kindString,
+	"cs-user-agent":                 kindEscaped,
+	"cs-referer":                    kindString,
+	"cs-cookie":                     kindCookies,
+	"cs-uri-query":                  kindString,
+	"x-edge-response-result-type":   kindString,
+	"x-forwarded-for":               kindString,
+	"ssl-protocol":                  kindString,
+	"ssl-cipher":                    kindString,
+	"x-edge-result-type":            kindString,
+	"fle-encrypted-fields":          kindString,
+	"fle-status":                    kindString,
+	"sc-content-type":               kindString,
+	"sc-content-len":                kindInt,
+	"sc-range-start":                kindInt,
+	"sc-range-end":                  kindInt,
+	"c-port":                        kindInt,
+	"x-edge-detailed-result-type":   kindString,
+	"c-country":                     kindString,
+	"cs-accept-encoding":            kindEscaped,
+	"cs-accept":                     kindString,
+	"cache-behavior-path-pattern":   kindString,
+	"cs-headers":                    kindHeaders,
+	"cs-header-names":               kindEscaped,
+	"cs-headers-count":              kindInt,
+	"primary-distribution-id":       kindString,
+	"primary-distribution-dns-name": kindString,
+	"origin-fbl":                    kindFloat,
+	"origin-lbl":                    kindFloat,
+	"asn":                           kindInt,
+}
+
+const (
+	// emptyValue is what CloudFront logs for fields without a value.
+	emptyValue = "-"
+	// parsedSuffix is appended to the key of the decoded value of an
+	// escaped field, the raw value is kept under the key itself. These are
+	// the fields the fluent-bit Lua filter used to add, so existing queries
+	// keep working.
+	parsedSuffix = "Parsed"
+)
+
+type field struct {
+	name string
+	key  string
+	kind fieldKind
+}
+
+// Parser parses tab separated real-time log lines with a fixed field order.
+type Parser struct {
+	fields []field
+}
+
+// NewParser returns a parser for lines containing fields in the given order.
+// The field names are the ones used in the CloudFront real-time log
+// configuration, e.g. "timestamp" or "cs-uri-stem".
+func NewParser(fields []string) (*Parser, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no fields configured")
+	}
+	p := &Parser{fields: make([]field, 0, len(fields))}
+	for _, name := range fields {
+		name = strings.TrimSpace(name)
+		kind, ok := knownFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cloudfront field: %s", name)
+		}
+		p.fields = append(p.fields, field{name: name, key: fieldKey(name), kind: kind})
+	}
+	return p, nil
+}
+
+// Entry is a single parsed log line.
+type Entry struct {
+	// Time is the time the request was served, taken from the timestamp
+	// field. It is zero if the line has no timestamp field or it cannot be
+	// parsed.
+	Time time.Time
+	// Fields contains the typed field values keyed by the camel cased field
+	// name, e.g. "csUriStem". Escaped fields are kept as logged, their
+	// decoded values are added as e.g. "csHeadersParsed". Fields logged as
+	// "-" are omitted.
+	Fields map[string]interface{}
+}
+
+// Parse parses a single log line.
+func (p *Parser) Parse(line string) (*Entry, error) {
+	values := strings.Split(strings.TrimRight(line, "\r\n"), "\t")
+	if len(values) != len(p.fields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(p.fields), len(values))
+	}
+	entry := &Entry{Fields: make(map[string]interface{}, len(values))}
+	for i, f := range p.fields {
+		value := values[i]
+		if value == emptyValue || value == "" {
+			continue
+		}
+		switch f.kind {
+		case kindInt:
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", f.name, err)
+			}
+			entry.Fields[f.key] = n
+		case kindFloat:
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", f.name, err)
+			}
+			entry.Fields[f.key] = n
+		case kindTime:
+			t, err := parseTimestamp(value)
+			if err != nil {
+				// keep the raw value and leave it to the caller to pick
+				// another time for the entry
+				entry.Fields[f.key] = value
+				continue
+			}
+			entry.Time = t
+			entry.Fields[f.key] = t.Format(time.RFC3339Nano)
+		case kindEscaped:
+			entry.Fields[f.key] = value
+			entry.Fields[f.key+parsedSuffix] = unescape(value)
+		case kindHeaders:
+			entry.Fields[f.key] = value
+			entry.Fields[f.key+parsedSuffix] = parseHeaders(unescape(value))
+		case kindCookies:
+			entry.Fields[f.key] = value
+			entry.Fields[f.key+parsedSuffix] = parseCookies(unescape(value))
+		case kindHost:
+			entry.Fields[f.key] = value
+			if domain, err := publicsuffix.EffectiveTLDPlusOne(value); err == nil {
+				entry.Fields[f.key+"Domain"] = domain
+			}
+		default:
+			entry.Fields[f.key] = value
+		}
+	}
+	return entry, nil
+}
+
+// fieldKey converts a field name like "cs-uri-stem" into "csUriStem".
+func fieldKey(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// parseTimestamp parses an epoch timestamp with millisecond precision, like
+// 1607374321.541.
+func parseTimestamp(value string) (time.Time, error) {
+	secs, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	whole, frac := math.Modf(secs)
+	return time.Unix(int64(whole), int64(math.Round(frac*1e3))*int64(time.Millisecond)).UTC(), nil
+}
+
+// unescape URL decodes value, returning it as is if it is not valid.
+func unescape(value string) string {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return value
+	}
+	return decoded
+}
+
+// parseHeaders parses newline separated "Name:value" pairs into a map keyed
+// by the lower cased header name.
+func parseHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.FieldsFunc(value, isNewline) {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		headers[strings.ToLower(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return headers
+}
+
+// parseCookies parses "name=value" pairs separated by semicolons into a map
+// keyed by the lower cased cookie name.
+func parseCookies(value string) map[string]string {
+	cookies := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		cookies[strings.ToLower(kv[0])] = unescape(kv[1])
+	}
+	return cookies
+}
+
+func isNewline(r rune) bool {
+	return r == '\n' || r == '\r'
+}
@@ -0,0 +1,135 @@
+package cloudfront
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var testLine = strings.Join([]string{
+	"1607374321.541",
+	"127.0.0.1",
+	"0.042",
+	"200",
+	"485",
+	"GET",
+	"http",
+	"test.cloudfront.net",
+	"/i",
+	"745",
+	"EWR52-C4",
+	"6PfZe0cc_AjXUjFuGnL9pGOmFdUx8xR8ZU8nr44JYJWi-DaeJjcxkw==",
+	"test.cloudfront.net",
+	"0.042",
+	"HTTP/1.1",
+	"IPv4",
+	"Mozilla/5.0%20(Macintosh;%20Intel%20Mac%20OS%20X%2010.15;%20rv:83.0)%20Gecko/20100101%20Firefox/83.0",
+	"http://localhost:5000/page-2",
+	"session=abc%3D1;%20Theme=dark",
+	"stm=1607374321563&e=pp",
+	"Miss",
+	"-",
+	"-",
+	"-",
+	"Miss",
+	"-",
+	"-",
+	"image/gif",
+	"35",
+	"-",
+	"-",
+	"49323",
+	"Miss",
+	"US",
+	"gzip,%20deflate",
+	"image/webp,*/*",
+	"*",
+	"Host:test.cloudfront.net%0AUser-Agent:Mozilla/5.0%0ADNT:1%0A",
+	"Host%0AUser-Agent%0ADNT%0A",
+	"3",
+}, "\t") + "\n"
+
+func TestParser(t *testing.T) {
+	Convey("Given a parser with the default fields", t, func() {
+		p, err := NewParser(DefaultFields)
+		So(err, ShouldBeNil)
+
+		Convey("When parsing a valid log line", func() {
+			entry, err := p.Parse(testLine)
+			So(err, ShouldBeNil)
+
+			Convey("Then the timestamp should be parsed with millisecond precision", func() {
+				So(entry.Time, ShouldEqual, time.Unix(1607374321, 541*int64(time.Millisecond)).UTC())
+				So(entry.Fields["timestamp"], ShouldEqual, "2020-12-07T20:52:01.541Z")
+			})
+			Convey("Then numeric fields should be typed", func() {
+				So(entry.Fields["scStatus"], ShouldEqual, int64(200))
+				So(entry.Fields["scBytes"], ShouldEqual, int64(485))
+				So(entry.Fields["cPort"], ShouldEqual, int64(49323))
+				So(entry.Fields["timeTaken"], ShouldEqual, 0.042)
+			})
+			Convey("Then the user agent should be URL decoded", func() {
+				So(entry.Fields["csUserAgentParsed"], ShouldEqual, "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:83.0) Gecko/20100101 Firefox/83.0")
+			})
+			Convey("Then headers and cookies should be parsed", func() {
+				So(entry.Fields["csHeadersParsed"], ShouldResemble, map[string]string{
+					"host":       "test.cloudfront.net",
+					"user-agent": "Mozilla/5.0",
+					"dnt":        "1",
+				})
+				So(entry.Fields["csHeaderNamesParsed"], ShouldEqual, "Host\nUser-Agent\nDNT\n")
+				So(entry.Fields["csCookieParsed"], ShouldResemble, map[string]string{
+					"session": "abc=1",
+					"theme":   "dark",
+				})
+			})
+			Convey("Then the raw values of escaped fields should be kept", func() {
+				So(entry.Fields["csUserAgent"], ShouldStartWith, "Mozilla/5.0%20(Macintosh;")
+				So(entry.Fields["csHeaders"], ShouldEqual, "Host:test.cloudfront.net%0AUser-Agent:Mozilla/5.0%0ADNT:1%0A")
+				So(entry.Fields["csHeaderNames"], ShouldEqual, "Host%0AUser-Agent%0ADNT%0A")
+			})
+			Convey("Then the registered domain should be set", func() {
+				So(entry.Fields["csHost"], ShouldEqual, "test.cloudfront.net")
+				So(entry.Fields["csHostDomain"], ShouldEqual, "test.cloudfront.net")
+			})
+			Convey("Then empty fields should be omitted", func() {
+				So(entry.Fields, ShouldNotContainKey, "xForwardedFor")
+				So(entry.Fields, ShouldNotContainKey, "scRangeStart")
+			})
+		})
+
+		Convey("When parsing a line with the wrong number of fields", func() {
+			_, err := p.Parse("1607374321.541\t127.0.0.1")
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When parsing a line with an invalid timestamp", func() {
+			entry, err := p.Parse(strings.Replace(testLine, "1607374321.541", "yesterday", 1))
+			So(err, ShouldBeNil)
+			So(entry.Time.IsZero(), ShouldBeTrue)
+			So(entry.Fields["timestamp"], ShouldEqual, "yesterday")
+		})
+
+		Convey("When parsing a line with an invalid status", func() {
+			_, err := p.Parse(strings.Replace(testLine, "\t200\t", "\tOK\t", 1))
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a parser with a custom field order", t, func() {
+		p, err := NewParser([]string{"sc-status", "timestamp", "cs-host"})
+		So(err, ShouldBeNil)
+		entry, err := p.Parse("404\t1607374321.5\twww.example.co.uk")
+		So(err, ShouldBeNil)
+		So(entry.Fields["scStatus"], ShouldEqual, int64(404))
+		So(entry.Time, ShouldEqual, time.Unix(1607374321, 500*int64(time.Millisecond)).UTC())
+		So(entry.Fields["csHostDomain"], ShouldEqual, "example.co.uk")
+	})
+
+	Convey("Given an unknown field", t, func() {
+		_, err := NewParser([]string{"timestamp", "no-such-field"})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,134 @@
+package firehose
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// accessKeyNameField is the record field forwarded messages carry the
+// name of the access key they were delivered with in.
+const accessKeyNameField = "accessKeyName"
+
+var (
+	errForbidden = &firehoseAPIError{code: http.StatusForbidden, msg: "event type not allowed for access key"}
+
+	// accessKeys are the keys Firehose requests are authenticated with.
+	accessKeys []AccessKey
+
+	accessKeyRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_access_key_requests_total",
+			Help: "Number of requests by access key name and authentication status",
+		},
+		[]string{"key", "status"},
+	)
+	accessKeyRecordsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_access_key_records_total",
+			Help: "Number of records received by access key name and type",
+		},
+		[]string{"key", "type"},
+	)
+)
+
+// AccessKey is a key Firehose delivery streams authenticate with. Keys
+// can be rotated by adding the new key with an overlapping validity
+// window.
+type AccessKey struct {
+	// Name identifies the key in forwarded records and metrics.
+	Name string
+	// Key is the secret configured in the delivery stream.
+	Key string
+	// NotBefore and NotAfter limit when the key is accepted. Zero times
+	// do not limit it.
+	NotBefore time.Time
+	NotAfter  time.Time
+	// EventTypes are the event types the key may deliver. All event types
+	// are allowed if it is empty.
+	EventTypes []string
+	// Tenant is the name of the tenant the key belongs to, if any.
+	Tenant string
+}
+
+// validateAccessKeys checks that there is at least one key and that the
+// keys are complete and uniquely named.
+func validateAccessKeys(keys []AccessKey) error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no access key configured")
+	}
+	names := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.Name == "" {
+			return fmt.Errorf("access key without a name")
+		}
+		if names[key.Name] {
+			return fmt.Errorf("duplicate access key name: %s", key.Name)
+		}
+		names[key.Name] = true
+		if key.Key == "" {
+			return fmt.Errorf("access key %s is empty", key.Name)
+		}
+		if !key.NotBefore.IsZero() && !key.NotAfter.IsZero() && !key.NotAfter.After(key.NotBefore) {
+			return fmt.Errorf("access key %s expires before it becomes valid", key.Name)
+		}
+	}
+	return nil
+}
+
+// allows reports whether the key may deliver events of eventType.
+func (k *AccessKey) allows(eventType string) bool {
+	if len(k.EventTypes) == 0 {
+		return true
+	}
+	for _, allowed := range k.EventTypes {
+		if allowed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// validAt reports whether the key is accepted at now.
+func (k *AccessKey) validAt(now time.Time) bool {
+	return (k.NotBefore.IsZero() || !now.Before(k.NotBefore)) &&
+		(k.NotAfter.IsZero() || now.Before(k.NotAfter))
+}
+
+// authenticate returns the access key matching key that is valid at now.
+// Every configured key is compared in constant time, so the time taken
+// does not tell which or how much of a key matched.
+func authenticate(key string, now time.Time) (*AccessKey, error) {
+	if key == "" {
+		accessKeyRequestsTotal.WithLabelValues("", "unauthorized").Inc()
+		return nil, errAuth
+	}
+	digest := sha256.Sum256([]byte(key))
+	var matched, expired *AccessKey
+	for i := range accessKeys {
+		candidate := sha256.Sum256([]byte(accessKeys[i].Key))
+		if subtle.ConstantTimeCompare(digest[:], candidate[:]) != 1 {
+			continue
+		}
+		if !accessKeys[i].validAt(now) {
+			expired = &accessKeys[i]
+		} else if matched == nil {
+			matched = &accessKeys[i]
+		}
+	}
+	if matched != nil {
+		return matched, nil
+	}
+	if expired != nil {
+		log.Warnf("access key %s used outside of its validity window", expired.Name)
+		accessKeyRequestsTotal.WithLabelValues(expired.Name, "expired").Inc()
+		return nil, errAuth
+	}
+	accessKeyRequestsTotal.WithLabelValues("", "unauthorized").Inc()
+	return nil, errAuth
+}
@@ -0,0 +1,118 @@
+package firehose
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuthenticate(t *testing.T) {
+	Convey("Given a set of access keys", t, func() {
+		now := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+		accessKeys = []AccessKey{
+			{Name: "current", Key: "current-key"},
+			{Name: "retired", Key: "retired-key", NotAfter: now.Add(-time.Hour)},
+			{Name: "upcoming", Key: "upcoming-key", NotBefore: now.Add(time.Hour)},
+			{Name: "rotating", Key: "rotating-key", NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+		}
+		Reset(func() {
+			accessKeys = nil
+		})
+
+		Convey("A known key should be matched by name", func() {
+			key, err := authenticate("current-key", now)
+			So(err, ShouldBeNil)
+			So(key.Name, ShouldEqual, "current")
+			key, err = authenticate("rotating-key", now)
+			So(err, ShouldBeNil)
+			So(key.Name, ShouldEqual, "rotating")
+		})
+
+		Convey("Unknown and empty keys should be rejected", func() {
+			_, err := authenticate("current", now)
+			So(err, ShouldEqual, errAuth)
+			_, err = authenticate("", now)
+			So(err, ShouldEqual, errAuth)
+		})
+
+		Convey("Keys outside of their window should be rejected", func() {
+			expired := testutil.ToFloat64(accessKeyRequestsTotal.WithLabelValues("retired", "expired"))
+			_, err := authenticate("retired-key", now)
+			So(err, ShouldEqual, errAuth)
+			So(testutil.ToFloat64(accessKeyRequestsTotal.WithLabelValues("retired", "expired"))-expired, ShouldEqual, 1)
+			_, err = authenticate("upcoming-key", now)
+			So(err, ShouldEqual, errAuth)
+			_, err = authenticate("upcoming-key", now.Add(time.Hour))
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestValidateAccessKeys(t *testing.T) {
+	Convey("Access keys should be validated", t, func() {
+		now := time.Now()
+		So(validateAccessKeys([]AccessKey{{Name: "a", Key: "a"}, {Name: "b", Key: "b"}}), ShouldBeNil)
+		So(validateAccessKeys(nil), ShouldNotBeNil)
+		So(validateAccessKeys([]AccessKey{{Key: "a"}}), ShouldNotBeNil)
+		So(validateAccessKeys([]AccessKey{{Name: "a"}}), ShouldNotBeNil)
+		So(validateAccessKeys([]AccessKey{{Name: "a", Key: "a"}, {Name: "a", Key: "b"}}), ShouldNotBeNil)
+		So(validateAccessKeys([]AccessKey{{Name: "a", Key: "a", NotBefore: now, NotAfter: now}}), ShouldNotBeNil)
+	})
+}
+
+func TestFirehoseHandlerAccessKeys(t *testing.T) {
+	eventTypeHeaderName = "X-EVENT-TYPE"
+	Convey("Given an access key limited to raw events", t, func() {
+		accessKeys = []AccessKey{{Name: "stream-a", Key: testToken, EventTypes: []string{"raw"}}}
+		forwarder = &closeTarget{}
+		send := func(eventType string) int {
+			body, _ := json.Marshal(firehoseRequestBody{
+				RequestID: "a",
+				Records:   []firehoseRecord{{Data: []byte(base64.StdEncoding.EncodeToString([]byte("hello")))}},
+			})
+			r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, "a")
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"`+eventType+`"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			return w.Code
+		}
+
+		Convey("Allowed event types should be accepted and counted by key", func() {
+			records := testutil.ToFloat64(accessKeyRecordsTotal.WithLabelValues("stream-a", "raw"))
+			So(send("raw"), ShouldEqual, http.StatusOK)
+			So(testutil.ToFloat64(accessKeyRecordsTotal.WithLabelValues("stream-a", "raw"))-records, ShouldEqual, 1)
+		})
+
+		Convey("Other event types should be forbidden", func() {
+			forbidden := testutil.ToFloat64(accessKeyRequestsTotal.WithLabelValues("stream-a", "forbidden"))
+			So(send("cloudwatchlogs"), ShouldEqual, http.StatusForbidden)
+			So(testutil.ToFloat64(accessKeyRequestsTotal.WithLabelValues("stream-a", "forbidden"))-forbidden, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestDecodeRecordAccessKeyName(t *testing.T) {
+	Convey("Given a record delivered with a named access key", t, func() {
+		record := &Record{
+			EventType:     "raw",
+			AccessKeyName: "stream-a",
+			Data:          []byte(base64.StdEncoding.EncodeToString([]byte("hello"))),
+		}
+		Convey("The key name should be added to the messages", func() {
+			msgs, err := decodeRecord(DecoderFunc(decodeRawEvent), record)
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 1)
+			So(msgs[0].Record.(protocol.Record)[accessKeyNameField], ShouldEqual, "stream-a")
+		})
+	})
+}
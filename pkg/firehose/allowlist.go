@@ -0,0 +1,116 @@
+package firehose
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const forwardedForHeaderName = "X-Forwarded-For"
+
+var (
+	errSourceForbidden = &firehoseAPIError{code: http.StatusForbidden, msg: "source address not allowed"}
+
+	// allowedNetworks are the networks requests are accepted from, from
+	// everywhere if it is empty.
+	allowedNetworks []*net.IPNet
+	// trustedProxies are the networks of the load balancers whose
+	// X-Forwarded-For header is trusted.
+	trustedProxies []*net.IPNet
+
+	sourceRejectedRequestsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fluenthose_source_rejected_requests_total",
+			Help: "Number of requests rejected because of their source address",
+		},
+	)
+)
+
+// parseNetworks parses CIDRs, single addresses are accepted as networks
+// of one address.
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address: %s", cidr)
+			}
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// containsIP reports whether ip is in one of networks.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIP returns the address a request originates from, nil if it is
+// invalid. Requests of trusted proxies originate from the last address of
+// X-Forwarded-For that is not a trusted proxy itself. The caller must hold
+// a read lock of configMu.
+func sourceIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if !containsIP(trustedProxies, ip) {
+		return ip
+	}
+	var hops []string
+	for _, header := range r.Header.Values(forwardedForHeaderName) {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	// proxies append the address they received the request from, so only
+	// the addresses added by trusted proxies can be relied on
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip = net.ParseIP(strings.TrimSpace(hops[i]))
+		if !containsIP(trustedProxies, ip) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// allowSources rejects requests that do not originate from the allowed
+// networks before they are passed to next.
+func allowSources(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configMu.RLock()
+		ip := sourceIP(r)
+		allowed := len(allowedNetworks) == 0 || containsIP(allowedNetworks, ip)
+		configMu.RUnlock()
+		if !allowed {
+			log.Warnf("rejected request from %s (remote address %s)", ip, r.RemoteAddr)
+			sourceRejectedRequestsTotal.Inc()
+			JSONHandleError(w, errSourceForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
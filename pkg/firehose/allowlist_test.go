@@ -0,0 +1,75 @@
+package firehose
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseNetworks(t *testing.T) {
+	Convey("CIDRs and single addresses should be parsed", t, func() {
+		networks, err := parseNetworks([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+		So(err, ShouldBeNil)
+		So(networks, ShouldHaveLength, 3)
+		So(networks[1].String(), ShouldEqual, "192.0.2.1/32")
+		So(networks[2].String(), ShouldEqual, "2001:db8::1/128")
+		_, err = parseNetworks([]string{"10.0.0.0/33"})
+		So(err, ShouldNotBeNil)
+		_, err = parseNetworks([]string{"firehose"})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAllowSources(t *testing.T) {
+	Convey("Given an allowlist", t, func() {
+		applyConfig(Config{
+			AllowedCIDRs:   []string{"192.0.2.0/24"},
+			TrustedProxies: []string{"10.0.0.0/8"},
+		}, nil, nil)
+		Reset(func() {
+			applyConfig(Config{}, nil, nil)
+		})
+		handler := allowSources(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		send := func(remoteAddr string, forwardedFor ...string) int {
+			r := httptest.NewRequest("POST", "/", nil)
+			r.RemoteAddr = remoteAddr
+			for _, header := range forwardedFor {
+				r.Header.Add(forwardedForHeaderName, header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w.Code
+		}
+
+		Convey("Requests from allowed networks should be accepted", func() {
+			So(send("192.0.2.10:4711"), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Requests from other networks should be rejected and counted", func() {
+			rejected := testutil.ToFloat64(sourceRejectedRequestsTotal)
+			So(send("198.51.100.1:4711"), ShouldEqual, http.StatusForbidden)
+			So(testutil.ToFloat64(sourceRejectedRequestsTotal)-rejected, ShouldEqual, 1)
+		})
+
+		Convey("X-Forwarded-For should only be used from trusted proxies", func() {
+			So(send("10.0.0.1:4711", "192.0.2.10"), ShouldEqual, http.StatusOK)
+			So(send("10.0.0.1:4711", "198.51.100.1"), ShouldEqual, http.StatusForbidden)
+			So(send("198.51.100.1:4711", "192.0.2.10"), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Addresses added by the client should be ignored", func() {
+			So(send("10.0.0.1:4711", "192.0.2.10, 198.51.100.1"), ShouldEqual, http.StatusForbidden)
+			So(send("10.0.0.1:4711", "198.51.100.1, 192.0.2.10, 10.0.0.2"), ShouldEqual, http.StatusOK)
+			So(send("10.0.0.1:4711", "198.51.100.1", "192.0.2.10"), ShouldEqual, http.StatusOK)
+			So(send("10.0.0.1:4711", "192.0.2.10, garbage"), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Requests should be accepted from everywhere without an allowlist", func() {
+			applyConfig(Config{}, nil, nil)
+			So(send("198.51.100.1:4711"), ShouldEqual, http.StatusOK)
+		})
+	})
+}
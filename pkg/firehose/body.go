@@ -0,0 +1,181 @@
+package firehose
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultMaxRequestBodyBytes = 64 * 1024 * 1024
+	defaultMaxDecodedBodyBytes = 64 * 1024 * 1024
+)
+
+var (
+	errTooLarge = &firehoseAPIError{code: http.StatusRequestEntityTooLarge, msg: "request entity too large"}
+	// errBodyLimit is returned by reads beyond a body limit.
+	errBodyLimit = errors.New("request body exceeds the size limit")
+
+	maxRequestBodyBytes int64 = defaultMaxRequestBodyBytes
+	maxDecodedBodyBytes int64 = defaultMaxDecodedBodyBytes
+	requestBytesTotal         = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_request_bytes_total",
+			Help: "Bytes of request bodies received by stage, as sent on the wire or after content decoding",
+		},
+		[]string{"stage"},
+	)
+)
+
+// SetMaxRequestBodyBytes sets the maximum size of a request body as sent
+// on the wire. Larger requests are answered with 413.
+func SetMaxRequestBodyBytes(n int64) {
+	if n <= 0 {
+		n = defaultMaxRequestBodyBytes
+	}
+	maxRequestBodyBytes = n
+}
+
+// SetMaxDecodedBodyBytes sets the maximum size of a request body after
+// content decoding, which guards against zip bombs. Larger requests are
+// answered with 413.
+func SetMaxDecodedBodyBytes(n int64) {
+	if n <= 0 {
+		n = defaultMaxDecodedBodyBytes
+	}
+	maxDecodedBodyBytes = n
+}
+
+// countingReader counts the bytes read from r in a counter.
+type countingReader struct {
+	r       io.Reader
+	counter prometheus.Counter
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.counter.Add(float64(n))
+	return n, err
+}
+
+// limitedReader reads from r and fails with errBodyLimit once more than n
+// bytes were read. If r is an http.MaxBytesReader with the same limit, its
+// error is reported as errBodyLimit as well.
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errBodyLimit
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		// withhold the byte past the limit so that a body cut off by
+		// the limit never decodes
+		return n + int(l.n), errBodyLimit
+	}
+	if l.n == 0 && err != nil && err != io.EOF {
+		return n, errBodyLimit
+	}
+	return n, err
+}
+
+// requestBodyReader returns a reader of the decoded request body. Bodies
+// with Content-Encoding gzip are decompressed. The returned reader fails
+// with errBodyLimit after maxRequestBodyBytes on the wire or
+// maxDecodedBodyBytes decoded.
+func requestBodyReader(r *http.Request) (io.Reader, error) {
+	body := io.Reader(&limitedReader{
+		r: &countingReader{r: r.Body, counter: requestBytesTotal.WithLabelValues("wire")},
+		n: maxRequestBodyBytes,
+	})
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		body = zr
+	default:
+		return nil, errors.New("unsupported content encoding: " + encoding)
+	}
+	return &limitedReader{
+		r: &countingReader{r: body, counter: requestBytesTotal.WithLabelValues("decoded")},
+		n: maxDecodedBodyBytes,
+	}, nil
+}
+
+// decodeRequestBody decodes a firehose request body from dec, calling fn
+// for every record instead of collecting them. Unknown fields are skipped,
+// an error returned by fn stops decoding and is returned as is.
+func decodeRequestBody(dec *json.Decoder, body *firehoseRequestBody, fn func(*firehoseRecord) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch token {
+		case "requestId":
+			err = dec.Decode(&body.RequestID)
+		case "timestamp":
+			err = dec.Decode(&body.Timestamp)
+		case "records":
+			err = decodeRecords(dec, fn)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// decodeRecords decodes the records array one record at a time.
+func decodeRecords(dec *json.Decoder, fn func(*firehoseRecord) error) error {
+	token, err := dec.Token()
+	if err != nil || token == nil {
+		return err
+	}
+	if token != json.Delim('[') {
+		return fmt.Errorf("expected records array, got %v", token)
+	}
+	for dec.More() {
+		var record firehoseRecord
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		if err := fn(&record); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("expected %v, got %v", delim, token)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func gzipBody(data []byte) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return &buf
+}
+
+// collectRecords parses the body of r and returns the records it streamed.
+func collectRecords(r *http.Request) (*firehoseRequestBody, []firehoseRecord, error) {
+	var records []firehoseRecord
+	body, err := parseRequestBody(r, func(record *firehoseRecord) error {
+		records = append(records, *record)
+		return nil
+	})
+	return body, records, err
+}
+
+func TestParseRequestBody(t *testing.T) {
+	Convey("Given a request body", t, func() {
+		body, _ := json.Marshal(validCloudwatchLogsEvent)
+
+		Convey("When it is gzip encoded", func() {
+			wire := testutil.ToFloat64(requestBytesTotal.WithLabelValues("wire"))
+			decoded := testutil.ToFloat64(requestBytesTotal.WithLabelValues("decoded"))
+			compressed := gzipBody(body)
+			compressedLen := compressed.Len()
+			r, _ := http.NewRequest("POST", "", compressed)
+			r.Header.Set("Content-Encoding", "gzip")
+			req, records, err := collectRecords(r)
+			Convey("Then it should be decompressed", func() {
+				So(err, ShouldBeNil)
+				So(req.RequestID, ShouldEqual, validCloudwatchLogsEvent.RequestID)
+				So(records, ShouldResemble, validCloudwatchLogsEvent.Records)
+			})
+			Convey("Then wire and decoded bytes should be counted", func() {
+				So(testutil.ToFloat64(requestBytesTotal.WithLabelValues("wire"))-wire, ShouldEqual, compressedLen)
+				So(testutil.ToFloat64(requestBytesTotal.WithLabelValues("decoded"))-decoded, ShouldEqual, len(body))
+			})
+		})
+
+		Convey("When it is not gzip despite the header", func() {
+			r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			r.Header.Set("Content-Encoding", "gzip")
+			_, _, err := collectRecords(r)
+			So(err, ShouldEqual, errBadReq)
+		})
+
+		Convey("When the encoding is unsupported", func() {
+			r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			r.Header.Set("Content-Encoding", "br")
+			_, _, err := collectRecords(r)
+			So(err, ShouldEqual, errBadReq)
+		})
+
+		Convey("When it decompresses beyond the limit", func() {
+			SetMaxDecodedBodyBytes(1024)
+			Reset(func() {
+				SetMaxDecodedBodyBytes(0)
+			})
+			decoded := testutil.ToFloat64(requestBytesTotal.WithLabelValues("decoded"))
+			r, _ := http.NewRequest("POST", "", gzipBody(bytes.Repeat([]byte(" "), 1024*1024)))
+			r.Header.Set("Content-Encoding", "gzip")
+			_, _, err := collectRecords(r)
+			Convey("Then it should be rejected as too large", func() {
+				So(err, ShouldEqual, errTooLarge)
+				code, _, _ := err.(*firehoseAPIError).APIError()
+				So(code, ShouldEqual, http.StatusRequestEntityTooLarge)
+			})
+			Convey("Then reading should stop at the limit", func() {
+				So(testutil.ToFloat64(requestBytesTotal.WithLabelValues("decoded"))-decoded, ShouldBeLessThan, 1024*1024)
+			})
+		})
+
+		Convey("When it is exactly at the limit", func() {
+			SetMaxDecodedBodyBytes(int64(len(body)))
+			Reset(func() {
+				SetMaxDecodedBodyBytes(0)
+			})
+			r, _ := http.NewRequest("POST", "", gzipBody(body))
+			r.Header.Set("Content-Encoding", "gzip")
+			_, _, err := collectRecords(r)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When it exceeds the wire limit", func() {
+			SetMaxRequestBodyBytes(int64(len(body) - 1))
+			Reset(func() {
+				SetMaxRequestBodyBytes(0)
+			})
+			Convey("Then a declared length should be rejected upfront", func() {
+				r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+				_, _, err := collectRecords(r)
+				So(err, ShouldEqual, errTooLarge)
+			})
+			Convey("Then a chunked body should be rejected while reading", func() {
+				r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+				r.ContentLength = -1
+				_, _, err := collectRecords(r)
+				So(err, ShouldEqual, errTooLarge)
+			})
+			Convey("Then the handler should answer with 413", func() {
+				accessKeys = []AccessKey{{Name: "test", Key: testToken}}
+				r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+				r.ContentLength = -1
+				r.Header.Set(accessKeyHeaderName, testToken)
+				r.Header.Set(requestIDHeaderName, validCloudwatchLogsEvent.RequestID)
+				w := httptest.NewRecorder()
+				firehoseHandler(w, r)
+				So(w.Code, ShouldEqual, http.StatusRequestEntityTooLarge)
+				So(w.Body.String(), ShouldContainSubstring, "request entity too large")
+			})
+		})
+	})
+
+	Convey("Given a request body with unknown fields", t, func() {
+		body := `{"requestId":"a","extra":{"nested":[1,2]},"timestamp":1,"records":[{"data":"aGVsbG8="},{"data":"d29ybGQ="}]}`
+		r, _ := http.NewRequest("POST", "", strings.NewReader(body))
+		req, records, err := collectRecords(r)
+		Convey("Then they should be skipped", func() {
+			So(err, ShouldBeNil)
+			So(req.RequestID, ShouldEqual, "a")
+			So(req.Timestamp, ShouldEqual, 1)
+			So(records, ShouldHaveLength, 2)
+			So(string(records[1].Data), ShouldEqual, "world")
+		})
+	})
+
+	Convey("Given a request body without records", t, func() {
+		r, _ := http.NewRequest("POST", "", strings.NewReader(`{"requestId":"a","records":null}`))
+		_, records, err := collectRecords(r)
+		So(err, ShouldBeNil)
+		So(records, ShouldBeEmpty)
+	})
+
+	Convey("Given a malformed request body", t, func() {
+		for _, body := range []string{``, `[]`, `{"records":{}}`, `{"records":[{"data":1}]}`, `{"records":[`} {
+			r, _ := http.NewRequest("POST", "", strings.NewReader(body))
+			_, _, err := collectRecords(r)
+			So(err, ShouldEqual, errBadReq)
+		}
+	})
+}
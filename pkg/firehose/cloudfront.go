@@ -0,0 +1,60 @@
+package firehose
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/BetssonGroup/fluenthose/pkg/cloudfront"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	log "github.com/sirupsen/logrus"
+)
+
+var cloudfrontParser, _ = cloudfront.NewParser(cloudfront.DefaultFields)
+
+// SetCloudfrontFields sets the field order of the CloudFront real-time log
+// configuration used to parse cloudfront records.
+func SetCloudfrontFields(fields []string) error {
+	parser, err := cloudfront.NewParser(fields)
+	if err != nil {
+		return err
+	}
+	cloudfrontParser = parser
+	return nil
+}
+
+// decodeCloudfrontEvent decodes a CloudFront real-time log record into one
+// message per log line.
+func decodeCloudfrontEvent(record *Record) ([]*protocol.MessageExt, error) {
+	log.Debugf("firehose record: %s", string(record.Data))
+	// decode base64 encoded data
+	decodedData, err := base64.StdEncoding.DecodeString(string(record.Data))
+	if err != nil {
+		log.Errorf("failed to decode base64 encoded data: %s", err)
+		return nil, err
+	}
+	log.Debugf("firehose record decoded: %s", decodedData)
+	var msgs []*protocol.MessageExt
+	for _, line := range strings.Split(string(decodedData), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := cloudfrontParser.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		entry.Fields["type"] = "cloudfront"
+		// use the time the request was served, falling back to the receive
+		// time if the line has no usable timestamp
+		timestamp := protocol.EventTimeNow()
+		if !entry.Time.IsZero() {
+			timestamp = protocol.EventTime{Time: entry.Time}
+		}
+		msgs = append(msgs, &protocol.MessageExt{
+			Tag:       "cloudfront",
+			Timestamp: timestamp,
+			Record:    entry.Fields,
+			Options:   &protocol.MessageOptions{},
+		})
+	}
+	return msgs, nil
+}
@@ -0,0 +1,107 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// cloudwatchlogsevent represents cloudwatchlogs event.
+type cloudWatchLogsEvent struct {
+	Owner               string                        `json:"owner"`
+	LogGroup            string                        `json:"logGroup"`
+	LogStream           string                        `json:"logStream"`
+	SubscriptionFilters []string                      `json:"subscriptionFilters"`
+	MessageType         string                        `json:"messageType"`
+	Timestamp           int64                         `json:"timestamp"`
+	LogEvents           []cloudWatchLogsEventLogEvent `json:"logEvents"`
+}
+
+type cloudWatchLogsEventLogEvent struct {
+	ID        string `json:"id"`
+	Message   string `json:"message"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// cloudWatchLogsControlMessage is the message type CloudWatch Logs uses to
+// check that a subscription destination is reachable.
+const cloudWatchLogsControlMessage = "CONTROL_MESSAGE"
+
+var cloudWatchLogsControlMessagesTotal = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "fluenthose_cloudwatchlogs_control_messages_total",
+		Help: "Number of CloudWatch Logs control messages dropped",
+	},
+)
+
+// decodeCloudwatchLogs decodes a CloudWatch Logs subscription record into
+// one message per log event. A record may contain several concatenated gzip
+// members, each holding one or more JSON documents. Control messages sent by
+// CloudWatch Logs to check the subscription are dropped.
+func decodeCloudwatchLogs(record *Record) ([]*protocol.MessageExt, error) {
+	// base64 decode and gunzip event data
+	decodedData, err := base64.StdEncoding.DecodeString(string(record.Data))
+	if err != nil {
+		return nil, err
+	}
+	// gzip.Reader reads concatenated members as a single stream
+	unzippedData, err := gzip.NewReader(bytes.NewReader(decodedData))
+	if err != nil {
+		return nil, err
+	}
+	defer unzippedData.Close()
+	var msgs []*protocol.MessageExt
+	dec := json.NewDecoder(unzippedData)
+	for {
+		var logRecord cloudWatchLogsEvent
+		err = dec.Decode(&logRecord)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		log.Debugf("cloudwatch log record: %+v", logRecord)
+		if logRecord.MessageType == cloudWatchLogsControlMessage {
+			log.Debugf("dropping cloudwatch logs control message for %s", logRecord.LogGroup)
+			cloudWatchLogsControlMessagesTotal.Inc()
+			continue
+		}
+		msgs = append(msgs, cloudWatchLogsMessages(&logRecord, record.RequestID)...)
+	}
+	return msgs, nil
+}
+
+// cloudWatchLogsMessages returns one message per log event of logRecord.
+func cloudWatchLogsMessages(logRecord *cloudWatchLogsEvent, requestID string) []*protocol.MessageExt {
+	var logGroupName = logRecord.LogGroup
+	var logStreamName = logRecord.LogStream
+	var logEvents = logRecord.LogEvents
+	msgs := make([]*protocol.MessageExt, 0, len(logEvents))
+	for _, logEvent := range logEvents {
+		msg := &protocol.MessageExt{
+			Tag:       "cloudwatchlogs",
+			Timestamp: protocol.EventTime{Time: time.Unix(0, logEvent.Timestamp*int64(time.Millisecond)).UTC()},
+			Record: map[string]interface{}{
+				"owner":         logRecord.Owner,
+				"logGroupName":  logGroupName,
+				"logStreamName": logStreamName,
+				"message":       logEvent.Message,
+				"timestamp":     logEvent.Timestamp,
+				"requestID":     requestID,
+				"type":          "cloudwatchlogs",
+			},
+			Options: &protocol.MessageOptions{},
+		}
+		log.Debugf("cloudwatch log message: %+v", msg)
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
@@ -0,0 +1,157 @@
+package firehose
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultReconnectBackoff    = 100 * time.Millisecond
+	defaultReconnectMaxBackoff = 30 * time.Second
+)
+
+var (
+	forwardConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_forward_connected",
+			Help: "Whether the connection to the fluent forwarder is established by upstream",
+		},
+		[]string{"upstream"},
+	)
+	forwardConnectsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_forward_connects_total",
+			Help: "Number of connection attempts to the fluent forwarder by upstream and status",
+		},
+		[]string{"upstream", "status"},
+	)
+)
+
+// forwardConnection manages the connection of a fluent forward client. It
+// connects lazily on the first send, drops the connection when a send fails
+// and reconnects with exponential backoff and jitter. Sends are serialized,
+// so messages of concurrent requests are never interleaved on the wire.
+type forwardConnection struct {
+	mu          sync.Mutex
+	upstream    string
+	client      *fluentclient.Client
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	failures    int
+	nextAttempt time.Time
+}
+
+// newForwardConnection returns a connection manager for client, which
+// connects to the given upstream address. Zero backoff durations are
+// replaced by the defaults.
+func newForwardConnection(upstream string, client *fluentclient.Client, backoff, maxBackoff time.Duration) *forwardConnection {
+	if backoff <= 0 {
+		backoff = defaultReconnectBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMaxBackoff
+	}
+	return &forwardConnection{
+		upstream:   upstream,
+		client:     client,
+		backoff:    backoff,
+		maxBackoff: maxBackoff,
+	}
+}
+
+// Send sends msg, connecting first if there is no connection.
+func (c *forwardConnection) Send(msg protocol.ChunkEncoder) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.connect(); err != nil {
+		return err
+	}
+	if err := c.client.SendMessage(msg); err != nil {
+		// the connection is in an unknown state, e.g. a late ack may still
+		// arrive, so start over with a new one
+		c.disconnect()
+		return err
+	}
+	return nil
+}
+
+// Check connects if there is no connection and reports whether the
+// forwarder is reachable. It is used as a readiness check.
+func (c *forwardConnection) Check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connect()
+}
+
+// Close closes the connection.
+func (c *forwardConnection) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.disconnect()
+}
+
+// connect establishes a connection unless there is one already or the
+// backoff after the last failed attempt has not passed yet.
+func (c *forwardConnection) connect() error {
+	if c.client.Session != nil {
+		return nil
+	}
+	now := time.Now()
+	if now.Before(c.nextAttempt) {
+		return fmt.Errorf("not connected to fluent forwarder %s, retrying in %s", c.upstream, c.nextAttempt.Sub(now).Round(time.Millisecond))
+	}
+	if err := c.dial(); err != nil {
+		delay := c.backoffDelay()
+		c.failures++
+		c.nextAttempt = now.Add(delay)
+		forwardConnectsTotal.WithLabelValues(c.upstream, "error").Inc()
+		log.Errorf("error connecting to fluent forwarder %s, retrying in %s: %s", c.upstream, delay, err)
+		return err
+	}
+	c.failures = 0
+	c.nextAttempt = time.Time{}
+	forwardConnectsTotal.WithLabelValues(c.upstream, "success").Inc()
+	forwardConnected.WithLabelValues(c.upstream).Set(1)
+	log.Infof("connected to fluent forwarder %s", c.upstream)
+	return nil
+}
+
+// dial opens a new connection and authenticates it if a shared key is
+// configured.
+func (c *forwardConnection) dial() error {
+	if err := c.client.Connect(); err != nil {
+		return err
+	}
+	if err := handshake(c.client, dialTimeout); err != nil {
+		c.client.Disconnect()
+		return fmt.Errorf("forward handshake failed: %w", err)
+	}
+	return nil
+}
+
+func (c *forwardConnection) disconnect() {
+	if c.client.Session != nil {
+		c.client.Disconnect()
+	}
+	forwardConnected.WithLabelValues(c.upstream).Set(0)
+}
+
+// backoffDelay returns the delay before the next connection attempt: an
+// exponentially growing delay capped at maxBackoff, with jitter spreading
+// it between half and the full value.
+func (c *forwardConnection) backoffDelay() time.Duration {
+	delay := c.maxBackoff
+	if c.failures < 32 {
+		if d := c.backoff << uint(c.failures); d > 0 && d < c.maxBackoff {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,88 @@
+package firehose
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestForwardConnection(t *testing.T) {
+	Convey("Given a forward connection", t, func() {
+		factory := &clientfakes.FakeConnectionFactory{}
+		factory.NewStub = discardConnection
+		conn := newForwardConnection("test", &fluentclient.Client{ConnectionFactory: factory}, time.Minute, time.Hour)
+		Reset(func() {
+			conn.Close()
+		})
+		msg := protocol.NewMessageExt("test", protocol.Record{"message": "hello"})
+
+		Convey("It should connect lazily on the first send", func() {
+			So(factory.NewCallCount(), ShouldEqual, 0)
+			So(conn.Send(msg), ShouldBeNil)
+			So(conn.Send(msg), ShouldBeNil)
+			So(factory.NewCallCount(), ShouldEqual, 1)
+			So(testutil.ToFloat64(forwardConnected.WithLabelValues("test")), ShouldEqual, 1)
+		})
+
+		Convey("When the forwarder is unavailable", func() {
+			factory.NewStub = func() (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			}
+			So(conn.Check(), ShouldNotBeNil)
+
+			Convey("Then it should not reconnect before the backoff passed", func() {
+				So(conn.Send(msg), ShouldNotBeNil)
+				So(factory.NewCallCount(), ShouldEqual, 1)
+				So(testutil.ToFloat64(forwardConnected.WithLabelValues("test")), ShouldEqual, 0)
+			})
+
+			Convey("Then it should reconnect once the backoff passed", func() {
+				factory.NewStub = discardConnection
+				conn.nextAttempt = time.Now()
+				So(conn.Send(msg), ShouldBeNil)
+				So(factory.NewCallCount(), ShouldEqual, 2)
+				So(conn.failures, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a send fails", func() {
+			factory.NewStub = func() (net.Conn, error) {
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			}
+			So(conn.Send(msg), ShouldNotBeNil)
+			Convey("Then the connection should be dropped", func() {
+				So(conn.client.Session, ShouldBeNil)
+				So(testutil.ToFloat64(forwardConnected.WithLabelValues("test")), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestBackoffDelay(t *testing.T) {
+	Convey("Given a connection with a 1s backoff capped at 10s", t, func() {
+		conn := newForwardConnection("test", &fluentclient.Client{}, time.Second, 10*time.Second)
+		Convey("The delay should grow exponentially with jitter", func() {
+			for failures, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second} {
+				conn.failures = failures
+				delay := conn.backoffDelay()
+				So(delay, ShouldBeGreaterThanOrEqualTo, max/2)
+				So(delay, ShouldBeLessThanOrEqualTo, max)
+			}
+		})
+		Convey("The delay should be capped", func() {
+			conn.failures = 100
+			delay := conn.backoffDelay()
+			So(delay, ShouldBeGreaterThanOrEqualTo, 5*time.Second)
+			So(delay, ShouldBeLessThanOrEqualTo, 10*time.Second)
+		})
+	})
+}
@@ -0,0 +1,280 @@
+package firehose
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const deadLetterSuffix = ".json"
+
+// deadLetterDir is the directory undecodable records are written to. They
+// are only logged if it is empty.
+var deadLetterDir string
+
+// deadLetterMu serializes the writes to the dead-letter directory, so a
+// record replaced by a retry is not counted twice.
+var deadLetterMu sync.Mutex
+
+var (
+	deadLetterEntries = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_dlq_entries",
+			Help: "Number of undecodable records in the dead-letter directory",
+		},
+	)
+	deadLetterBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_dlq_bytes",
+			Help: "Size of the undecodable records in the dead-letter directory",
+		},
+	)
+)
+
+// deadLetter is an undecodable record as stored in the dead-letter
+// directory.
+type deadLetter struct {
+	RequestID     string    `json:"requestId"`
+	EventType     string    `json:"eventType"`
+	AccessKeyName string    `json:"accessKeyName,omitempty"`
+	Error         string    `json:"error"`
+	Timestamp     time.Time `json:"timestamp"`
+	Data          []byte    `json:"data"`
+}
+
+// SetDeadLetterDir sets the directory undecodable records are written to,
+// creating it if needed. Records are only logged if dir is empty.
+func SetDeadLetterDir(dir string) error {
+	var entries int
+	var size int64
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+		var err error
+		if entries, size, err = deadLetterStats(dir); err != nil {
+			return err
+		}
+	}
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	deadLetterDir = dir
+	deadLetterEntries.Set(float64(entries))
+	deadLetterBytes.Set(float64(size))
+	return nil
+}
+
+// writeDeadLetter stores a record that failed to decode, index is its
+// position in the request. The file is named after the request ID and the
+// index, so the retries of a request that fails later on replace the
+// records written before rather than adding duplicates.
+func writeDeadLetter(record *Record, index int, decodeErr error) error {
+	if deadLetterDir == "" {
+		return nil
+	}
+	letter := deadLetter{
+		RequestID:     record.RequestID,
+		EventType:     record.EventType,
+		AccessKeyName: record.AccessKeyName,
+		Error:         decodeErr.Error(),
+		Timestamp:     time.Now().UTC(),
+		Data:          record.Data,
+	}
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so a replay never sees a partial
+	// record
+	tmp, err := ioutil.TempFile(deadLetterDir, "*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	// the request ID is sent by the client, so it is hashed rather than
+	// used as a file name
+	id := sha256.Sum256([]byte(record.RequestID))
+	name := filepath.Join(deadLetterDir, fmt.Sprintf("%x-%d%s", id[:16], index, deadLetterSuffix))
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	replaced, statErr := os.Stat(name)
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if statErr == nil {
+		deadLetterBytes.Sub(float64(replaced.Size()))
+	} else {
+		deadLetterEntries.Inc()
+	}
+	deadLetterBytes.Add(float64(len(data)))
+	return nil
+}
+
+// deadLetterFiles returns the records in dir, oldest first, so records are
+// replayed in the order they failed.
+func deadLetterFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+deadLetterSuffix))
+	if err != nil {
+		return nil, err
+	}
+	modified := make(map[string]time.Time, len(files))
+	for _, file := range files {
+		if fi, err := os.Stat(file); err == nil {
+			modified[file] = fi.ModTime()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !modified[files[i]].Equal(modified[files[j]]) {
+			return modified[files[i]].Before(modified[files[j]])
+		}
+		return files[i] < files[j]
+	})
+	return files, nil
+}
+
+// deadLetterStats returns the number and the total size of the records in
+// dir.
+func deadLetterStats(dir string) (int, int64, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	var entries int
+	var size int64
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && strings.HasSuffix(fi.Name(), deadLetterSuffix) {
+			entries++
+			size += fi.Size()
+		}
+	}
+	return entries, size, nil
+}
+
+// ReplayDeadLetters decodes the records in dir with the current decoders
+// and forwards them like the endpoint configured by config would: records
+// of access keys belonging to a tenant get its tag prefix and go to its
+// upstreams. Records are deleted once forwarded, records that still fail
+// to decode or whose access key is no longer configured are kept. It stops
+// at the first record that cannot be forwarded.
+func ReplayDeadLetters(dir string, config Config) (replayed, failed int, err error) {
+	if err := validateTenants(config.Tenants, config.AccessKeys); err != nil {
+		return 0, 0, err
+	}
+	if err := config.Forward.validate(); err != nil {
+		return 0, 0, fmt.Errorf("invalid forward options: %w", err)
+	}
+	pool, err := newForwarder(config.ForwardAddresses, config.Forward)
+	if err != nil {
+		return 0, 0, err
+	}
+	routes, _, err := newTenantRoutes(config, nil, ForwardOptions{})
+	if err != nil {
+		pool.Close()
+		return 0, 0, err
+	}
+	configMu.Lock()
+	applyConfig(config, pool, routes)
+	configMu.Unlock()
+	defer func() {
+		pool.Close()
+		for _, route := range routes {
+			if route.forwarder != nil {
+				route.forwarder.Close()
+			}
+		}
+	}()
+	keyTenants := make(map[string]string, len(config.AccessKeys))
+	for _, key := range config.AccessKeys {
+		keyTenants[key.Name] = key.Tenant
+	}
+
+	files, err := deadLetterFiles(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return replayed, failed, err
+		}
+		var letter deadLetter
+		if err := json.Unmarshal(data, &letter); err != nil {
+			log.Errorf("skipping invalid dead letter %s: %s", file, err)
+			failed++
+			continue
+		}
+		decoder := decoderFor(letter.EventType)
+		if decoder == nil {
+			log.Errorf("skipping dead letter %s: no decoder for event type %s", file, letter.EventType)
+			failed++
+			continue
+		}
+		msgs, err := decodeRecord(decoder, &Record{
+			EventType:     letter.EventType,
+			RequestID:     letter.RequestID,
+			AccessKeyName: letter.AccessKeyName,
+			Data:          letter.Data,
+		})
+		if err != nil {
+			log.Errorf("dead letter %s still fails to decode: %s", file, err)
+			failed++
+			continue
+		}
+		tenant, ok := keyTenants[letter.AccessKeyName]
+		if !ok && letter.AccessKeyName != "" {
+			// the tenant of the key is unknown, its records may not
+			// belong to the default upstreams
+			log.Errorf("skipping dead letter %s: access key %s is not configured", file, letter.AccessKeyName)
+			failed++
+			continue
+		}
+		routes[tenant].tag(msgs)
+		configMu.RLock()
+		target, err := targetFor(tenant)
+		configMu.RUnlock()
+		if err != nil {
+			return replayed, failed, err
+		}
+		if err := forwardMessages(target, config.Forward, letter.EventType, msgs); err != nil {
+			return replayed, failed, err
+		}
+		if err := removeDeadLetter(dir, file, int64(len(data))); err != nil {
+			return replayed, failed, err
+		}
+		replayed++
+	}
+	return replayed, failed, nil
+}
+
+// removeDeadLetter deletes a replayed record of dir, size is its size.
+func removeDeadLetter(dir, file string, size int64) error {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	if dir == deadLetterDir {
+		deadLetterEntries.Dec()
+		deadLetterBytes.Sub(float64(size))
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package firehose
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tinylib/msgp/msgp"
+)
+
+func TestDeadLetters(t *testing.T) {
+	Convey("Given a dead-letter directory", t, func() {
+		dir := t.TempDir()
+		So(SetDeadLetterDir(dir), ShouldBeNil)
+		So(testutil.ToFloat64(deadLetterEntries), ShouldEqual, 0)
+		Reset(func() {
+			SetDeadLetterDir("")
+		})
+
+		Convey("When a record fails to decode in the handler", func() {
+			accessKeys = []AccessKey{{Name: "test", Key: testToken}}
+			eventTypeHeaderName = "X-EVENT-TYPE"
+			factory := &clientfakes.FakeConnectionFactory{}
+			factory.NewStub = discardConnection
+			forwarder = newForwardConnection("test", &fluentclient.Client{ConnectionFactory: factory}, time.Minute, time.Minute)
+			Reset(func() {
+				forwarder.Close()
+			})
+			body, _ := json.Marshal(firehoseRequestBody{
+				RequestID: "e4ac4ebd-3f41-4ae9-a2b6-2ba4ff0a6a4b",
+				Timestamp: 1600110760000,
+				Records:   []firehoseRecord{{Data: []byte("not a gzip payload")}},
+			})
+			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			So(err, ShouldBeNil)
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, "e4ac4ebd-3f41-4ae9-a2b6-2ba4ff0a6a4b")
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"cloudwatchlogs"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			Convey("Then the raw record should be written with its context", func() {
+				files, err := deadLetterFiles(dir)
+				So(err, ShouldBeNil)
+				So(files, ShouldHaveLength, 1)
+				data, err := ioutil.ReadFile(files[0])
+				So(err, ShouldBeNil)
+				var letter deadLetter
+				So(json.Unmarshal(data, &letter), ShouldBeNil)
+				So(letter.RequestID, ShouldEqual, "e4ac4ebd-3f41-4ae9-a2b6-2ba4ff0a6a4b")
+				So(letter.EventType, ShouldEqual, "cloudwatchlogs")
+				So(letter.Error, ShouldNotBeEmpty)
+				So(letter.Timestamp, ShouldHappenWithin, time.Minute, time.Now())
+				So(string(letter.Data), ShouldEqual, "not a gzip payload")
+			})
+
+			Convey("Then the DLQ size should be reported", func() {
+				So(testutil.ToFloat64(deadLetterEntries), ShouldEqual, 1)
+				So(testutil.ToFloat64(deadLetterBytes), ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When a request is retried", func() {
+			record := &Record{RequestID: "../retried", EventType: "raw", Data: []byte("%%%")}
+			So(writeDeadLetter(record, 0, errors.New("illegal base64 data")), ShouldBeNil)
+			So(writeDeadLetter(record, 1, errors.New("illegal base64 data")), ShouldBeNil)
+			So(writeDeadLetter(record, 0, errors.New("illegal base64 data")), ShouldBeNil)
+			Convey("Then its records should only be written once", func() {
+				files, err := deadLetterFiles(dir)
+				So(err, ShouldBeNil)
+				So(files, ShouldHaveLength, 2)
+				So(testutil.ToFloat64(deadLetterEntries), ShouldEqual, 2)
+				_, size, err := deadLetterStats(dir)
+				So(err, ShouldBeNil)
+				So(testutil.ToFloat64(deadLetterBytes), ShouldEqual, size)
+			})
+		})
+
+		Convey("When replaying dead letters", func() {
+			fixed := &Record{RequestID: "fixed", EventType: "raw", Data: []byte(base64.StdEncoding.EncodeToString([]byte("hello")))}
+			broken := &Record{RequestID: "broken", EventType: "raw", Data: []byte("%%%")}
+			So(writeDeadLetter(fixed, 0, errors.New("decoder bug")), ShouldBeNil)
+			So(writeDeadLetter(broken, 0, errors.New("illegal base64 data")), ShouldBeNil)
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			Reset(func() {
+				listener.Close()
+			})
+			go func() {
+				for {
+					conn, err := listener.Accept()
+					if err != nil {
+						return
+					}
+					go io.Copy(ioutil.Discard, conn)
+				}
+			}()
+
+			replayed, failed, err := ReplayDeadLetters(dir, Config{ForwardAddresses: []string{listener.Addr().String()}})
+			Convey("Then decodable records should be forwarded and deleted", func() {
+				So(err, ShouldBeNil)
+				So(replayed, ShouldEqual, 1)
+				So(failed, ShouldEqual, 1)
+				files, _ := deadLetterFiles(dir)
+				So(files, ShouldHaveLength, 1)
+				data, _ := ioutil.ReadFile(files[0])
+				So(string(data), ShouldContainSubstring, `"requestId":"broken"`)
+				So(testutil.ToFloat64(deadLetterEntries), ShouldEqual, 1)
+				So(testutil.ToFloat64(deadLetterBytes), ShouldEqual, len(data))
+			})
+		})
+
+		Convey("When replaying dead letters of a tenant", func() {
+			record := &Record{RequestID: "tenant", EventType: "raw", AccessKeyName: "payments-key", Data: []byte(base64.StdEncoding.EncodeToString([]byte("hello")))}
+			So(writeDeadLetter(record, 0, errors.New("decoder bug")), ShouldBeNil)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			Reset(func() {
+				listener.Close()
+			})
+			tags := make(chan string, 1)
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				r := msgp.NewReader(conn)
+				if _, err := r.ReadArrayHeader(); err != nil {
+					return
+				}
+				tag, _ := r.ReadString()
+				tags <- tag
+			}()
+			// the default upstream is down, the record must go to the tenant
+			down, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			down.Close()
+
+			replayed, _, err := ReplayDeadLetters(dir, Config{
+				AccessKeys:       []AccessKey{{Name: "payments-key", Tenant: "payments"}},
+				ForwardAddresses: []string{down.Addr().String()},
+				Tenants:          []Tenant{{Name: "payments", TagPrefix: "payments", ForwardAddresses: []string{listener.Addr().String()}}},
+			})
+			Convey("Then they should be forwarded to its upstreams with its tag prefix", func() {
+				So(err, ShouldBeNil)
+				So(replayed, ShouldEqual, 1)
+				select {
+				case tag := <-tags:
+					So(tag, ShouldEqual, "payments.raw")
+				case <-time.After(5 * time.Second):
+					So("no message received", ShouldBeEmpty)
+				}
+			})
+		})
+
+		Convey("When replaying dead letters of a removed access key", func() {
+			record := &Record{RequestID: "removed", EventType: "raw", AccessKeyName: "removed-key", Data: []byte(base64.StdEncoding.EncodeToString([]byte("hello")))}
+			So(writeDeadLetter(record, 0, errors.New("decoder bug")), ShouldBeNil)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			Reset(func() {
+				listener.Close()
+			})
+
+			replayed, failed, err := ReplayDeadLetters(dir, Config{ForwardAddresses: []string{listener.Addr().String()}})
+			Convey("Then they should be kept rather than sent to the default upstreams", func() {
+				So(err, ShouldBeNil)
+				So(replayed, ShouldEqual, 0)
+				So(failed, ShouldEqual, 1)
+				files, _ := deadLetterFiles(dir)
+				So(files, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When the forwarder is down during a replay", func() {
+			record := &Record{RequestID: "fixed", EventType: "raw", Data: []byte(base64.StdEncoding.EncodeToString([]byte("hello")))}
+			So(writeDeadLetter(record, 0, errors.New("decoder bug")), ShouldBeNil)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			So(err, ShouldBeNil)
+			address := listener.Addr().String()
+			listener.Close()
+
+			_, _, err = ReplayDeadLetters(dir, Config{ForwardAddresses: []string{address}})
+			Convey("Then the records should be kept", func() {
+				So(err, ShouldNotBeNil)
+				files, _ := deadLetterFiles(dir)
+				So(files, ShouldHaveLength, 1)
+			})
+		})
+	})
+}
+
+func TestDeadLetterStats(t *testing.T) {
+	Convey("Given a dead-letter directory with records", t, func() {
+		dir := t.TempDir()
+		So(ioutil.WriteFile(filepath.Join(dir, "a"+deadLetterSuffix), []byte("12345"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "b"+deadLetterSuffix), []byte("123"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "c.tmp"), []byte("1234567"), 0600), ShouldBeNil)
+
+		Convey("When it is set on start", func() {
+			So(SetDeadLetterDir(dir), ShouldBeNil)
+			Reset(func() {
+				SetDeadLetterDir("")
+			})
+			Convey("Then the records should be counted without temporary files", func() {
+				So(testutil.ToFloat64(deadLetterEntries), ShouldEqual, 2)
+				So(testutil.ToFloat64(deadLetterBytes), ShouldEqual, 8)
+			})
+		})
+	})
+}
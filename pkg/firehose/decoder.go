@@ -0,0 +1,152 @@
+package firehose
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+)
+
+// Record is a single firehose record handed to a Decoder together with the
+// request metadata it was delivered with.
+type Record struct {
+	EventType string
+	RequestID string
+	// AccessKeyName is the name of the access key the record was
+	// delivered with.
+	AccessKeyName string
+	Data          []byte
+}
+
+// Decoder decodes a single firehose record into zero or more forward
+// messages. Decoders are registered per event type with RegisterDecoder.
+type Decoder interface {
+	Decode(record *Record) ([]*protocol.MessageExt, error)
+}
+
+// DecoderFunc adapts an ordinary function to the Decoder interface.
+type DecoderFunc func(record *Record) ([]*protocol.MessageExt, error)
+
+// Decode calls f(record).
+func (f DecoderFunc) Decode(record *Record) ([]*protocol.MessageExt, error) {
+	return f(record)
+}
+
+// unknownEventType is the event type of requests without one, and the
+// metric label of event types without a registered decoder.
+const unknownEventType = "unknown"
+
+var (
+	decodersMu      sync.RWMutex
+	decoders        = make(map[string]Decoder)
+	fallbackDecoder Decoder
+)
+
+func init() {
+	RegisterDecoder("cloudwatchlogs", DecoderFunc(decodeCloudwatchLogs))
+	RegisterDecoder("cloudfront", DecoderFunc(decodeCloudfrontEvent))
+	RegisterDecoder("raw", DecoderFunc(decodeRawEvent))
+}
+
+// RegisterDecoder makes a decoder available for the given event type. If
+// RegisterDecoder is called twice with the same event type or if decoder is
+// nil, it panics.
+func RegisterDecoder(eventType string, decoder Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if decoder == nil {
+		panic("firehose: RegisterDecoder decoder is nil")
+	}
+	if _, dup := decoders[eventType]; dup {
+		panic("firehose: RegisterDecoder called twice for event type " + eventType)
+	}
+	decoders[eventType] = decoder
+}
+
+// Decoders returns a sorted list of the registered event types.
+func Decoders() []string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	list := make([]string, 0, len(decoders))
+	for eventType := range decoders {
+		list = append(list, eventType)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// SetFallbackDecoder routes records of unregistered event types to the
+// decoder registered for eventType. An empty eventType drops such records.
+func SetFallbackDecoder(eventType string) error {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	if eventType == "" {
+		fallbackDecoder = nil
+		return nil
+	}
+	decoder, ok := decoders[eventType]
+	if !ok {
+		return fmt.Errorf("unknown fallback event type: %s", eventType)
+	}
+	fallbackDecoder = decoder
+	return nil
+}
+
+// decoderFor returns the decoder for eventType, the fallback decoder if none
+// is registered, or nil if the records should be dropped.
+func decoderFor(eventType string) Decoder {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	if decoder, ok := decoders[eventType]; ok {
+		return decoder
+	}
+	return fallbackDecoder
+}
+
+// metricEventType returns eventType as a metric label. Event types are
+// chosen by the sender, so those without a registered decoder are counted
+// as unknown to keep the number of series bounded.
+func metricEventType(eventType string) string {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	if _, ok := decoders[eventType]; ok {
+		return eventType
+	}
+	return unknownEventType
+}
+
+// decodeRecord decodes record with decoder and adds the name of the access
+// key it was delivered with to the messages.
+func decodeRecord(decoder Decoder, record *Record) ([]*protocol.MessageExt, error) {
+	msgs, err := decoder.Decode(record)
+	if err != nil || record.AccessKeyName == "" {
+		return msgs, err
+	}
+	for _, msg := range msgs {
+		switch fields := msg.Record.(type) {
+		case protocol.Record:
+			fields[accessKeyNameField] = record.AccessKeyName
+		case map[string]interface{}:
+			fields[accessKeyNameField] = record.AccessKeyName
+		}
+	}
+	return msgs, nil
+}
+
+// decodeRawEvent forwards the base64 decoded record as is, tagged with the
+// event type it was delivered with.
+func decodeRawEvent(record *Record) ([]*protocol.MessageExt, error) {
+	decodedData, err := base64.StdEncoding.DecodeString(string(record.Data))
+	if err != nil {
+		return nil, err
+	}
+	msg := protocol.NewMessageExt(record.EventType, protocol.Record{
+		"data":      string(decodedData),
+		"requestID": record.RequestID,
+		"type":      record.EventType,
+	})
+	msg.Options = &protocol.MessageOptions{}
+	return []*protocol.MessageExt{msg}, nil
+}
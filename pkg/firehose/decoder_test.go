@@ -0,0 +1,164 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/BetssonGroup/fluenthose/pkg/cloudfront"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDecoderRegistry(t *testing.T) {
+	Convey("Given the default decoder registry", t, func() {
+		Reset(func() {
+			So(SetFallbackDecoder(""), ShouldBeNil)
+		})
+
+		Convey("The built-in event types should be registered", func() {
+			So(Decoders(), ShouldResemble, []string{"cloudfront", "cloudwatchlogs", "raw"})
+			So(decoderFor("cloudfront"), ShouldNotBeNil)
+			So(decoderFor("cloudwatchlogs"), ShouldNotBeNil)
+		})
+
+		Convey("CloudFront records should be parsed into typed fields", func() {
+			msgs, err := decoderFor("cloudfront").Decode(&Record{
+				EventType: "cloudfront",
+				RequestID: validCloudFrontEvent.RequestID,
+				Data:      validCloudFrontEvent.Records[0].Data,
+			})
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 1)
+			So(msgs[0].Tag, ShouldEqual, "cloudfront")
+			So(msgs[0].Timestamp.Time, ShouldEqual, time.Unix(1607374321, 541*int64(time.Millisecond)).UTC())
+			record := msgs[0].Record.(map[string]interface{})
+			So(record["type"], ShouldEqual, "cloudfront")
+			So(record["scStatus"], ShouldEqual, int64(200))
+			So(record["csHeadersCount"], ShouldEqual, int64(8))
+		})
+
+		Convey("Unknown event types should be dropped without a fallback", func() {
+			So(decoderFor("unknown"), ShouldBeNil)
+		})
+
+		Convey("Unknown event types should use the fallback decoder", func() {
+			So(SetFallbackDecoder("raw"), ShouldBeNil)
+			decoder := decoderFor("unknown")
+			So(decoder, ShouldNotBeNil)
+			msgs, err := decoder.Decode(&Record{
+				EventType: "unknown",
+				RequestID: "test",
+				Data:      []byte(base64.StdEncoding.EncodeToString([]byte("hello"))),
+			})
+			So(err, ShouldBeNil)
+			So(msgs, ShouldHaveLength, 1)
+			So(msgs[0].Tag, ShouldEqual, "unknown")
+			So(msgs[0].Record, ShouldResemble, protocol.Record{
+				"data":      "hello",
+				"requestID": "test",
+				"type":      "unknown",
+			})
+		})
+
+		Convey("Setting an unregistered fallback should fail", func() {
+			So(SetFallbackDecoder("nope"), ShouldNotBeNil)
+		})
+
+		Convey("Registering a decoder twice should panic", func() {
+			So(func() {
+				RegisterDecoder("raw", DecoderFunc(decodeRawEvent))
+			}, ShouldPanic)
+		})
+	})
+}
+
+func TestDecodeCloudfrontEventTime(t *testing.T) {
+	Convey("Given a cloudfront record without a usable timestamp", t, func() {
+		line := "yesterday\t127.0.0.1\t0.042\t200\n"
+		So(SetCloudfrontFields([]string{"timestamp", "c-ip", "time-taken", "sc-status"}), ShouldBeNil)
+		Reset(func() {
+			So(SetCloudfrontFields(cloudfront.DefaultFields), ShouldBeNil)
+		})
+		before := time.Now()
+		msgs, err := decodeCloudfrontEvent(&Record{
+			EventType: "cloudfront",
+			Data:      []byte(base64.StdEncoding.EncodeToString([]byte(line))),
+		})
+		So(err, ShouldBeNil)
+		So(msgs, ShouldHaveLength, 1)
+		Convey("Then the receive time should be used", func() {
+			So(msgs[0].Timestamp.Time, ShouldHappenOnOrAfter, before)
+			So(msgs[0].Timestamp.Time, ShouldHappenOnOrBefore, time.Now())
+		})
+	})
+}
+
+func TestDecodeCloudwatchLogsEventTime(t *testing.T) {
+	Convey("Given a cloudwatch logs record", t, func() {
+		msgs, err := decodeCloudwatchLogs(&Record{
+			EventType: "cloudwatchlogs",
+			RequestID: validCloudwatchLogsEvent.RequestID,
+			Data:      validCloudwatchLogsEvent.Records[0].Data,
+		})
+		So(err, ShouldBeNil)
+		So(msgs, ShouldHaveLength, 2)
+		Convey("Then the millisecond timestamps should be preserved", func() {
+			So(msgs[0].Timestamp.Time, ShouldEqual, time.Unix(1600110569, 39*int64(time.Millisecond)).UTC())
+			So(msgs[1].Timestamp.Time, ShouldEqual, time.Unix(1600110569, 41*int64(time.Millisecond)).UTC())
+		})
+	})
+}
+
+// gzipRecord returns the base64 encoded concatenation of one gzip member per
+// payload.
+func gzipRecord(payloads ...string) []byte {
+	var buf bytes.Buffer
+	for _, payload := range payloads {
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(payload))
+		zw.Close()
+	}
+	return []byte(base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+func TestDecodeCloudwatchLogsPayloads(t *testing.T) {
+	const (
+		controlMessage = `{"messageType":"CONTROL_MESSAGE","owner":"CloudwatchLogs","logGroup":"","logStream":"","subscriptionFilters":[],"logEvents":[{"id":"","timestamp":1600110569039,"message":"CWL CONTROL MESSAGE: Checking health of destination Firehose."}]}`
+		dataMessage1   = `{"messageType":"DATA_MESSAGE","owner":"1","logGroup":"g1","logStream":"s1","logEvents":[{"id":"1","timestamp":1600110569039,"message":"one"}]}`
+		dataMessage2   = `{"messageType":"DATA_MESSAGE","owner":"1","logGroup":"g2","logStream":"s2","logEvents":[{"id":"2","timestamp":1600110569040,"message":"two"},{"id":"3","timestamp":1600110569041,"message":"three"}]}`
+	)
+	Convey("Given a record with a control message", t, func() {
+		before := testutil.ToFloat64(cloudWatchLogsControlMessagesTotal)
+		msgs, err := decodeCloudwatchLogs(&Record{EventType: "cloudwatchlogs", Data: gzipRecord(controlMessage)})
+		So(err, ShouldBeNil)
+		Convey("Then it should be dropped and counted", func() {
+			So(msgs, ShouldBeEmpty)
+			So(testutil.ToFloat64(cloudWatchLogsControlMessagesTotal), ShouldEqual, before+1)
+		})
+	})
+
+	Convey("Given a record with concatenated gzip members", t, func() {
+		msgs, err := decodeCloudwatchLogs(&Record{EventType: "cloudwatchlogs", Data: gzipRecord(dataMessage1, controlMessage, dataMessage2)})
+		So(err, ShouldBeNil)
+		Convey("Then the log events of every member should be decoded", func() {
+			So(msgs, ShouldHaveLength, 3)
+			So(msgs[0].Record.(map[string]interface{})["message"], ShouldEqual, "one")
+			So(msgs[2].Record.(map[string]interface{})["logGroupName"], ShouldEqual, "g2")
+		})
+	})
+
+	Convey("Given a gzip member with several JSON documents", t, func() {
+		msgs, err := decodeCloudwatchLogs(&Record{EventType: "cloudwatchlogs", Data: gzipRecord(dataMessage1 + "\n" + dataMessage2)})
+		So(err, ShouldBeNil)
+		So(msgs, ShouldHaveLength, 3)
+	})
+
+	Convey("Given a record with a truncated JSON document", t, func() {
+		_, err := decodeCloudwatchLogs(&Record{EventType: "cloudwatchlogs", Data: gzipRecord(dataMessage1[:20])})
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,204 @@
+package firehose
+
+import (
+	"bufio"
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// requests is the cache of delivered request IDs. Retries are not
+// detected if it is nil.
+var requests *requestCache
+
+var duplicateRequestsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "fluenthose_duplicate_requests_total",
+		Help: "Number of retried requests acknowledged without forwarding by type",
+	},
+	[]string{"type"},
+)
+
+// SetRequestDedupe enables acknowledging Firehose retries of requests that
+// were already delivered without forwarding them again. Request IDs are
+// remembered for ttl, at most maxEntries of them. If file is set, the
+// cache is persisted to it and survives restarts. Deduplication is
+// disabled if ttl is zero.
+func SetRequestDedupe(ttl time.Duration, maxEntries int, file string) error {
+	if ttl <= 0 {
+		requests = nil
+		return nil
+	}
+	if maxEntries <= 0 {
+		return fmt.Errorf("invalid maximum number of request IDs: %d", maxEntries)
+	}
+	c, err := newRequestCache(ttl, maxEntries, file)
+	if err != nil {
+		return err
+	}
+	requests = c
+	return nil
+}
+
+// requestCache is a bounded set of request IDs expiring after a TTL. If
+// persisted, additions are appended to a file that is compacted once it
+// holds twice as many lines as the cache.
+type requestCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List
+	path       string
+	file       *os.File
+	lines      int
+}
+
+type requestCacheEntry struct {
+	id      string
+	expires time.Time
+}
+
+func newRequestCache(ttl time.Duration, maxEntries int, path string) (*requestCache, error) {
+	c := &requestCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+		path:       path,
+	}
+	if path == "" {
+		return c, nil
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the unexpired request IDs from the cache file.
+func (c *requestCache) load() error {
+	f, err := os.Open(c.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	now := time.Now()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		nanos, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if expires := time.Unix(0, nanos); expires.After(now) {
+			c.insert(fields[1], expires)
+		}
+	}
+	return scanner.Err()
+}
+
+// Seen reports whether id was added and has not expired yet.
+func (c *requestCache) Seen(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	return ok && e.Value.(*requestCacheEntry).expires.After(time.Now())
+}
+
+// Add remembers id for the TTL of the cache.
+func (c *requestCache) Add(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	c.insert(id, expires)
+	if c.file == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(c.file, "%d\t%s\n", expires.UnixNano(), id); err != nil {
+		log.Errorf("failed to persist request ID: %s", err)
+	}
+	c.lines++
+	if c.lines > 2*c.maxEntries {
+		if err := c.compact(); err != nil {
+			log.Errorf("failed to compact request ID cache: %s", err)
+		}
+	}
+}
+
+// insert adds id at the end of the order and evicts expired entries and,
+// if the cache is full, the oldest ones.
+func (c *requestCache) insert(id string, expires time.Time) {
+	if e, ok := c.entries[id]; ok {
+		c.order.Remove(e)
+	}
+	c.entries[id] = c.order.PushBack(&requestCacheEntry{id: id, expires: expires})
+	now := time.Now()
+	for front := c.order.Front(); front != nil; front = c.order.Front() {
+		entry := front.Value.(*requestCacheEntry)
+		if entry.expires.After(now) && c.order.Len() <= c.maxEntries {
+			break
+		}
+		c.order.Remove(front)
+		delete(c.entries, entry.id)
+	}
+}
+
+// compact rewrites the cache file with the current entries.
+func (c *requestCache) compact() error {
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0640)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*requestCacheEntry)
+		fmt.Fprintf(w, "%d\t%s\n", entry.expires.UnixNano(), entry.id)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.file, err = os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	c.lines = c.order.Len()
+	return nil
+}
+
+// Close closes the cache file.
+func (c *requestCache) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+}
@@ -0,0 +1,126 @@
+package firehose
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestRequestCache(t *testing.T) {
+	Convey("Given a request cache", t, func() {
+		c, err := newRequestCache(time.Hour, 3, "")
+		So(err, ShouldBeNil)
+
+		Convey("Added request IDs should be seen", func() {
+			So(c.Seen("a"), ShouldBeFalse)
+			c.Add("a")
+			So(c.Seen("a"), ShouldBeTrue)
+		})
+
+		Convey("The oldest request IDs should be evicted when full", func() {
+			for _, id := range []string{"a", "b", "c", "d"} {
+				c.Add(id)
+			}
+			So(c.Seen("a"), ShouldBeFalse)
+			So(c.Seen("d"), ShouldBeTrue)
+			So(c.order.Len(), ShouldEqual, 3)
+		})
+
+		Convey("Request IDs should expire after the TTL", func() {
+			c.ttl = time.Millisecond
+			c.Add("a")
+			time.Sleep(5 * time.Millisecond)
+			So(c.Seen("a"), ShouldBeFalse)
+			c.Add("b")
+			So(c.entries, ShouldNotContainKey, "a")
+		})
+	})
+
+	Convey("Given a persisted request cache", t, func() {
+		path := filepath.Join(t.TempDir(), "requests")
+		c, err := newRequestCache(time.Hour, 3, path)
+		So(err, ShouldBeNil)
+		c.Add("a")
+		c.Add("b")
+		c.Close()
+
+		Convey("Request IDs should survive a restart", func() {
+			c, err := newRequestCache(time.Hour, 3, path)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			So(c.Seen("a"), ShouldBeTrue)
+			So(c.Seen("b"), ShouldBeTrue)
+		})
+
+		Convey("The file should be compacted", func() {
+			c, err := newRequestCache(time.Hour, 3, path)
+			So(err, ShouldBeNil)
+			defer c.Close()
+			for i := 0; i < 10; i++ {
+				c.Add("c")
+			}
+			So(c.lines, ShouldBeLessThanOrEqualTo, 6)
+			c.Close()
+			c, err = newRequestCache(time.Hour, 3, path)
+			So(err, ShouldBeNil)
+			So(c.Seen("a"), ShouldBeTrue)
+			So(c.Seen("c"), ShouldBeTrue)
+		})
+	})
+}
+
+func TestFirehoseHandlerDedupe(t *testing.T) {
+	accessKeys = []AccessKey{{Name: "test", Key: testToken}}
+	eventTypeHeaderName = "X-EVENT-TYPE"
+	Convey("Given request deduplication", t, func() {
+		So(SetRequestDedupe(time.Hour, 100, ""), ShouldBeNil)
+		factory := &clientfakes.FakeConnectionFactory{}
+		factory.NewStub = discardConnection
+		forwarder = newForwardConnection("test", &fluentclient.Client{ConnectionFactory: factory}, time.Minute, time.Minute)
+		Reset(func() {
+			forwarder.Close()
+			SetRequestDedupe(0, 0, "")
+		})
+		send := func() int {
+			body, _ := json.Marshal(validCloudwatchLogsEvent)
+			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			So(err, ShouldBeNil)
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, validCloudwatchLogsEvent.RequestID)
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"cloudwatchlogs"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			return w.Code
+		}
+
+		Convey("When a delivered request is retried", func() {
+			duplicates := testutil.ToFloat64(duplicateRequestsTotal.WithLabelValues("cloudwatchlogs"))
+			So(send(), ShouldEqual, http.StatusOK)
+			So(send(), ShouldEqual, http.StatusOK)
+			Convey("Then it should be acknowledged without forwarding", func() {
+				So(factory.NewCallCount(), ShouldEqual, 1)
+				So(testutil.ToFloat64(duplicateRequestsTotal.WithLabelValues("cloudwatchlogs"))-duplicates, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When a failed request is retried", func() {
+			factory.NewStub = refuseConnection
+			So(send(), ShouldEqual, http.StatusServiceUnavailable)
+			factory.NewStub = discardConnection
+			forwarder.(*forwardConnection).nextAttempt = time.Now()
+			Convey("Then it should be forwarded", func() {
+				So(send(), ShouldEqual, http.StatusOK)
+				So(factory.NewCallCount(), ShouldEqual, 2)
+			})
+		})
+	})
+}
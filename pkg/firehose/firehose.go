@@ -1,17 +1,14 @@
 package firehose
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/base64"
 	"encoding/json"
-	"io/ioutil"
-	"net"
+	"errors"
+	stdlog "log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -23,7 +20,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	muxlogrus "github.com/pytimer/mux-logrus"
 
-	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -31,14 +27,17 @@ const (
 	accessKeyHeaderName        = "X-Amz-Firehose-Access-Key"
 	requestIDHeaderName        = "X-Amz-Firehose-Request-Id"
 	commonAttributesHeaderName = "X-Amz-Firehose-Common-Attributes"
+	// requestChunkMessages is the number of decoded messages a request
+	// buffers before they are forwarded or appended to the write-ahead log.
+	requestChunkMessages = 1000
 )
 
 var (
-	errAuth       = &firehoseAPIError{code: http.StatusUnauthorized, msg: "unauthorized"}
-	errBadReq     = &firehoseAPIError{code: http.StatusBadRequest, msg: "bad request"}
-	forwardClient *fluentclient.Client
-	accessKey     string
-	eventsTotal   = prometheus.NewCounterVec(
+	errAuth     = &firehoseAPIError{code: http.StatusUnauthorized, msg: "unauthorized"}
+	errBadReq   = &firehoseAPIError{code: http.StatusBadRequest, msg: "bad request"}
+	forwarder   forwardTarget
+	wal         *walSet
+	eventsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "fluenthose_events_total",
 			Help: "Number of events processed by type",
@@ -49,7 +48,7 @@ var (
 )
 
 func init() {
-	prometheus.MustRegister(eventsTotal)
+	prometheus.MustRegister(eventsTotal, cloudWatchLogsControlMessagesTotal, batchEntries, batchBytes, compressionBytesTotal, forwardConnected, forwardConnectsTotal, upstreamHealthy, upstreamInflight, upstreamSendsTotal, walBytes, walAppendsTotal, walDroppedSegmentsTotal, deadLetterEntries, deadLetterBytes, duplicateRequestsTotal, requestBytesTotal, reloadsTotal, accessKeyRequestsTotal, accessKeyRecordsTotal, tenantRequestsTotal, tlsCertificateExpiry, sourceRejectedRequestsTotal)
 }
 
 type APIError interface {
@@ -94,51 +93,47 @@ type firehoseResponseBody struct {
 	ErrorMessage string `json:"errorMessage,omitempty"`
 }
 
-// cloudwatchlogsevent represents cloudwatchlogs event.
-type cloudWatchLogsEvent struct {
-	Owner               string                        `json:"owner"`
-	LogGroup            string                        `json:"logGroup"`
-	LogStream           string                        `json:"logStream"`
-	SubscriptionFilters []string                      `json:"subscriptionFilters"`
-	MessageType         string                        `json:"messageType"`
-	Timestamp           int64                         `json:"timestamp"`
-	LogEvents           []cloudWatchLogsEventLogEvent `json:"logEvents"`
-}
-
-type cloudWatchLogsEventLogEvent struct {
-	ID        string `json:"id"`
-	Message   string `json:"message"`
-	Timestamp int64  `json:"timestamp"`
-}
-
-func RunFirehoseServer(address, key, forwardAddress, eventTypeHeader string) {
-	eventTypeHeaderName = eventTypeHeader
-	accessKey = key
-	forwardHost, forwardPort, err := net.SplitHostPort(forwardAddress)
+// RunFirehoseServer serves Firehose requests on address until the process
+// is interrupted. The configuration can be replaced with Reload.
+func RunFirehoseServer(address string, config Config) {
+	if err := config.validate(); err != nil {
+		log.Fatalf("%s", err)
+	}
+	pool, err := newForwarder(config.ForwardAddresses, config.Forward)
 	if err != nil {
-		log.Fatalf("Failed to parse forward address: %s", err)
-	}
-	forwardPortInt, _ := strconv.Atoi(forwardPort)
-	forwardClient = &fluentclient.Client{
-		ConnectionFactory: &fluentclient.TCPConnectionFactory{
-			Target: fluentclient.ServerAddress{
-				Hostname: forwardHost,
-				Port:     forwardPortInt,
-			},
-		},
+		log.Fatalf("%s", err)
 	}
-	err = forwardClient.Connect()
+	routes, _, err := newTenantRoutes(config, nil, ForwardOptions{})
 	if err != nil {
-		log.Fatalf("error connecting to fluent forwarder: %s", err)
+		log.Fatalf("%s", err)
+	}
+	applyConfig(config, pool, routes)
+	if config.TLS.Enabled() {
+		tlsConfig, err := config.TLS.config()
+		if err != nil {
+			log.Fatalf("failed to load TLS settings: %s", err)
+		}
+		applyServerTLS(tlsConfig)
+	}
+	// connect eagerly, but keep serving if the forwarder is not up yet, the
+	// connection is retried when records arrive
+	if err := forwarder.Check(); err != nil {
+		log.Warnf("fluent forwarder is not available yet: %s", err)
 	}
 
 	health := healthcheck.NewHandler()
-	health.AddLivenessCheck(
-		"forwarder",
-		healthcheck.TCPDialCheck(forwardAddress, 50*time.Millisecond))
-	health.AddReadinessCheck(
-		"forwarder",
-		healthcheck.TCPDialCheck(forwardAddress, 50*time.Millisecond))
+	if forwardOptions.WAL.Dir != "" {
+		wal, err = openWALSet(forwardOptions.WAL)
+		if err != nil {
+			log.Fatalf("failed to open write-ahead log: %s", err)
+		}
+		wal.Start(forwardCurrent)
+		// records are buffered while the forwarder is down, so only stop
+		// taking traffic when the buffer is full
+		health.AddReadinessCheck("wal", wal.Check)
+	} else {
+		health.AddReadinessCheck("forwarder", checkForwarder)
+	}
 
 	logOptions := muxlogrus.LogOptions{
 		Formatter:      &log.JSONFormatter{},
@@ -147,7 +142,7 @@ func RunFirehoseServer(address, key, forwardAddress, eventTypeHeader string) {
 	loggingMiddleware := muxlogrus.NewLogger(logOptions)
 
 	router := mux.NewRouter()
-	router.Handle("/", loggingMiddleware.Middleware(http.HandlerFunc(firehoseHandler))).Methods("POST")
+	router.Handle("/", loggingMiddleware.Middleware(allowSources(requireClientCert(http.HandlerFunc(firehoseHandler))))).Methods("POST")
 	router.Handle("/metrics", promhttp.Handler())
 	router.HandleFunc("/health/live", health.LiveEndpoint)
 	router.HandleFunc("/health/ready", health.ReadyEndpoint)
@@ -155,23 +150,46 @@ func RunFirehoseServer(address, key, forwardAddress, eventTypeHeader string) {
 	srv := &http.Server{
 		Addr:    address,
 		Handler: router,
+		// log TLS handshake errors like the other messages
+		ErrorLog: stdlog.New(log.StandardLogger().WriterLevel(log.WarnLevel), "", 0),
 	}
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
+	ln, err := listen(address, config)
+	if err != nil {
+		log.Fatalf("listen: %s\n", err)
+	}
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
-	log.Infof("Fluenthose server listening on %s", address)
-	log.Debugf("log-level: %s, fowarding to: %s", log.GetLevel(), forwardAddress)
+	if config.TLS.Enabled() {
+		log.Infof("Fluenthose server listening on %s with TLS", address)
+	} else {
+		log.Infof("Fluenthose server listening on %s", address)
+	}
+	log.Debugf("log-level: %s, fowarding to: %s", log.GetLevel(), strings.Join(config.ForwardAddresses, ", "))
 	<-done
 
 	// shutdown gracefully
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer func() {
-		forwardClient.Disconnect()
+		if wal != nil {
+			wal.Close()
+		}
+		configMu.Lock()
+		forwarder.Close()
+		for _, route := range tenants {
+			if route.forwarder != nil {
+				route.forwarder.Close()
+			}
+		}
+		configMu.Unlock()
+		if requests != nil {
+			requests.Close()
+		}
 		cancel()
 	}()
 
@@ -183,12 +201,28 @@ func RunFirehoseServer(address, key, forwardAddress, eventTypeHeader string) {
 
 func firehoseHandler(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("firehose %s request received from %s", r.Method, r.RemoteAddr)
-	key := r.Header.Get(accessKeyHeaderName)
-	if key == "" || key != accessKey {
-		JSONHandleError(w, errAuth)
+	// take what the request uses from the configuration up front, so a
+	// reload does not wait for the request to complete
+	configMu.RLock()
+	key, err := authenticate(r.Header.Get(accessKeyHeaderName), time.Now())
+	if err != nil {
+		configMu.RUnlock()
+		JSONHandleError(w, err)
+		return
+	}
+	eventType := parseEventType(r)
+	eventTypeLabel := metricEventType(eventType)
+	tenant := tenants[key.Tenant]
+	// the tenants of the access keys are validated with them, so this
+	// only fails on a bug
+	snapshot, err := snapshotForward(key.Tenant)
+	configMu.RUnlock()
+	if err != nil {
+		log.Errorf("failed to route request: %s", err)
+		JSONHandleError(w, err)
 		return
-
 	}
+	defer snapshot.release()
 
 	requestID := r.Header.Get(requestIDHeaderName)
 	if requestID == "" {
@@ -203,119 +237,125 @@ func firehoseHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf("%s request from %s", r.Method, r.RemoteAddr)
 	log.Debugf("request headers: %+v", r.Header)
-	log.Debugf("body: %s", r.Body)
 
-	eventType := parseEventType(r)
-	firehoseReq, err := parseRequestBody(r)
-	if err != nil {
-		log.Errorf("failed to parse request body: %s", err)
-		JSONHandleError(w, errBadReq)
+	if !key.allows(eventType) || !tenant.allows(eventType) {
+		log.Warnf("access key %s may not deliver %s events", key.Name, eventType)
+		accessKeyRequestsTotal.WithLabelValues(key.Name, "forbidden").Inc()
+		tenant.count("forbidden")
+		JSONHandleError(w, errForbidden)
 		return
 	}
-
-	for _, record := range firehoseReq.Records {
-		switch eventType {
-		case "cloudwatchlogs":
-			if err = forwardCloudwatchLog(record.Data, requestID); err != nil {
-				log.Errorf("failed to forward cloudwatchlogs event: %s", err)
-				continue
+	if !tenant.allow() {
+		log.Warnf("tenant %s exceeds its rate limit", tenant.Name)
+		tenant.count("rate_limited")
+		JSONHandleError(w, errRateLimited)
+		return
+	}
+	accessKeyRequestsTotal.WithLabelValues(key.Name, "accepted").Inc()
+	tenant.count("accepted")
+	if requests != nil && requests.Seen(requestID) {
+		// a retry of a request that was delivered, but whose response
+		// did not reach firehose in time
+		log.Infof("request %s was already delivered, skipping", requestID)
+		duplicateRequestsTotal.WithLabelValues(eventTypeLabel).Inc()
+		writeFirehoseResponse(w, resp)
+		return
+	}
+	decoder := decoderFor(eventType)
+	var msgs []*protocol.MessageExt
+	// flush hands the decoded messages on in chunks while the body is
+	// read, so memory stays bounded by the chunk rather than the request.
+	// If a later chunk fails the earlier ones were delivered anyway, the
+	// retry of the request delivers them again.
+	flush := func() error {
+		if len(msgs) == 0 {
+			return nil
+		}
+		defer func() { msgs = nil }()
+		if wal != nil {
+			if err := wal.Append(key.Tenant, eventType, msgs); err != nil {
+				// let firehose retry the request or back it up to S3
+				log.Errorf("failed to buffer %s event: %s", eventType, err)
+				eventsTotal.WithLabelValues(eventTypeLabel, "error").Add(float64(len(msgs)))
+				return &firehoseAPIError{
+					code:      http.StatusServiceUnavailable,
+					msg:       "failed to buffer records",
+					requestID: requestID,
+				}
 			}
-		case "cloudfront":
-			if err = forwardCloudfrontEvent(record.Data, requestID); err != nil {
-				log.Errorf("failed to forward cloudfront event: %s", err)
-				continue
+		} else if err := snapshot.forward(eventType, msgs); err != nil {
+			// let firehose retry the request or back it up to S3
+			log.Errorf("failed to forward %s event: %s", eventType, err)
+			return &firehoseAPIError{
+				code:      http.StatusServiceUnavailable,
+				msg:       "failed to forward records",
+				requestID: requestID,
 			}
-		default: // do nothing
 		}
+		return nil
 	}
-	resp.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(resp)
-}
-
-func forwardCloudfrontEvent(data []byte, requestID string) error {
-	var recordCount = 0
-	log.Debugf("firehose record: %s", string(data))
-	// decode base64 encoded data
-	decodedData, err := base64.StdEncoding.DecodeString(string(data))
-	if err != nil {
-		log.Errorf("failed to decode base64 encoded data: %s", err)
-		return err
-	}
-	log.Debugf("firehose record decoded: %s", decodedData)
-	recordCount++
-	msg := &protocol.Message{
-		Tag:       "cloudfront",
-		Timestamp: time.Now().UTC().Unix(),
-		Record: map[string]interface{}{
-			"data": string(decodedData),
-			"type": "cloudfront",
-		},
-		Options: &protocol.MessageOptions{},
+	records := 0
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+	}
+	_, err = parseRequestBody(r, func(record *firehoseRecord) error {
+		index := records
+		records++
+		if decoder == nil {
+			return nil
+		}
+		rec := &Record{
+			EventType:     eventType,
+			RequestID:     requestID,
+			AccessKeyName: key.Name,
+			Data:          record.Data,
+		}
+		recordMsgs, err := decodeRecord(decoder, rec)
+		if err != nil {
+			eventsTotal.WithLabelValues(eventTypeLabel, "error").Inc()
+			log.Errorf("failed to decode %s event: %s", eventType, err)
+			if err := writeDeadLetter(rec, index, err); err != nil {
+				log.Errorf("failed to write dead letter: %s", err)
+			}
+			return nil
+		}
+		tenant.tag(recordMsgs)
+		msgs = append(msgs, recordMsgs...)
+		if len(msgs) >= requestChunkMessages {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
 	}
-	err = forwardClient.SendMessage(msg)
 	if err != nil {
-		eventsTotal.WithLabelValues("eventType", "error").Inc()
-		log.Errorf("failed to send message: %s", err)
-	} else {
-		eventsTotal.WithLabelValues("eventType", "success").Inc()
-		log.Infof("%d records sent to fluent forwarder", recordCount)
-
+		log.Errorf("failed to process request body: %s", err)
+		JSONHandleError(w, err)
+		return
 	}
-	return nil
-}
 
-func forwardCloudwatchLog(data []byte, requestID string) error {
-	// base64 decode and gunzip event data
-	decodedData, err := base64.StdEncoding.DecodeString(string(data))
-	if err != nil {
-		return err
+	accessKeyRecordsTotal.WithLabelValues(key.Name, eventTypeLabel).Add(float64(records))
+	if decoder == nil {
+		log.Debugf("no decoder for event type %s, dropping %d records", eventType, records)
+		eventsTotal.WithLabelValues(eventTypeLabel, "dropped").Add(float64(records))
 	}
-	unzippedData, err := gzip.NewReader(bytes.NewReader(decodedData))
-	if err != nil {
-		return err
+	if requests != nil {
+		requests.Add(requestID)
 	}
-	defer unzippedData.Close()
-	var logRecord cloudWatchLogsEvent
-	err = json.NewDecoder(unzippedData).Decode(&logRecord)
-	if err != nil {
-		return err
-	}
-	log.Debugf("cloudwatch log record: %+v", logRecord)
-	var logGroupName = logRecord.LogGroup
-	var logStreamName = logRecord.LogStream
-	var logEvents = logRecord.LogEvents
-	for _, logEvent := range logEvents {
-		msg := &protocol.Message{
-			Tag:       "cloudwatchlogs",
-			Timestamp: logEvent.Timestamp,
-			Record: map[string]interface{}{
-				"owner":         logRecord.Owner,
-				"logGroupName":  logGroupName,
-				"logStreamName": logStreamName,
-				"message":       logEvent.Message,
-				"timestamp":     logEvent.Timestamp,
-				"requestID":     requestID,
-				"type":          "cloudwatchlogs",
-			},
-			Options: &protocol.MessageOptions{},
-		}
-		log.Debugf("cloudwatch log message: %+v", msg)
-		err := forwardClient.SendMessage(msg)
-		if err != nil {
-			eventsTotal.WithLabelValues("eventType", "error").Inc()
-			log.Errorf("failed to send message: %s", err)
-		} else {
-			eventsTotal.WithLabelValues("eventType", "success").Inc()
-			log.Infof("%d records sent to fluent forwarder", 1)
-		}
-	}
-	return nil
+	writeFirehoseResponse(w, resp)
+}
+
+// writeFirehoseResponse answers a request successfully.
+func writeFirehoseResponse(w http.ResponseWriter, resp firehoseResponseBody) {
+	resp.Timestamp = time.Now().UnixNano() / int64(time.Millisecond)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
 }
 
 func parseEventType(r *http.Request) string {
-	var eventType = "unknown"
+	var eventType = unknownEventType
 	commonAttributes := firehoseCommonAttributes{}
 	if err := json.Unmarshal([]byte(r.Header.Get(commonAttributesHeaderName)), &commonAttributes); err != nil {
 		log.Errorf("failed to parse common attributes: %s", err)
@@ -335,18 +375,35 @@ func parseEventType(r *http.Request) string {
 	return eventType
 }
 
-func parseRequestBody(r *http.Request) (*firehoseRequestBody, error) {
+// parseRequestBody decodes the request body, calling fn for every record
+// as it is read so that a batch is never held in memory as a whole. The
+// returned body carries no records. An APIError returned by fn stops
+// decoding and is returned unchanged.
+func parseRequestBody(r *http.Request, fn func(*firehoseRecord) error) (*firehoseRequestBody, error) {
 	body := firehoseRequestBody{}
-	logBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Errorf("failed to read request body: %s", err)
-	}
-	r.Body = ioutil.NopCloser(bytes.NewBuffer(logBody))
 	if r.Body == nil {
 		log.Errorf("request body is empty")
 		return nil, errBadReq
 	}
-	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+	if r.ContentLength > maxRequestBodyBytes {
+		log.Errorf("request body of %d bytes exceeds %d bytes", r.ContentLength, maxRequestBodyBytes)
+		return nil, errTooLarge
+	}
+	bodyReader, err := requestBodyReader(r)
+	if err != nil {
+		log.Errorf("failed to decode request body: %s", err)
+		return nil, errBadReq
+	}
+	err = decodeRequestBody(json.NewDecoder(bodyReader), &body, fn)
+	var apiErr APIError
+	if errors.As(err, &apiErr) {
+		return nil, err
+	}
+	if errors.Is(err, errBodyLimit) {
+		log.Errorf("request body exceeds the size limit")
+		return nil, errTooLarge
+	}
+	if err != nil {
 		log.Errorf("failed to decode request body: %s", err)
 		return nil, errBadReq
 	}
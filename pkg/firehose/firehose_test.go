@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -13,6 +16,7 @@ import (
 
 	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
 	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/testcontainers/testcontainers-go"
 )
@@ -47,14 +51,27 @@ func init() {
 	//log.SetLevel(log.DebugLevel)
 }
 
+// discardConnection returns a connection that accepts and discards every
+// message written to it.
+func discardConnection() (net.Conn, error) {
+	client, server := net.Pipe()
+	go io.Copy(ioutil.Discard, server)
+	return client, nil
+}
+
 func TestFirehoseHandler(t *testing.T) {
-	accessKey = testToken
+	accessKeys = []AccessKey{{Name: "test", Key: testToken}}
+	eventTypeHeaderName = "X-EVENT-TYPE"
 	Convey("Given the firehose handler is invoked", t, func() {
 		factory = &clientfakes.FakeConnectionFactory{}
-		forwardClient = &fluentclient.Client{
+		factory.NewStub = discardConnection
+		forwarder = newForwardConnection("test", &fluentclient.Client{
 			ConnectionFactory: factory,
 			Timeout:           2 * time.Second,
-		}
+		}, time.Minute, time.Minute)
+		Reset(func() {
+			forwarder.Close()
+		})
 		Convey("When called without a token", func() {
 			r, err := http.NewRequest("POST", "", bytes.NewBuffer([]byte(`{"commonAttributes":{"X-EVENT-TYPE":"test"}}`)))
 			So(err, ShouldBeNil)
@@ -123,6 +140,26 @@ func TestFirehoseHandler(t *testing.T) {
 			})
 
 		})
+		Convey("When called with an event type without a decoder", func() {
+			dropped := testutil.ToFloat64(eventsTotal.WithLabelValues(unknownEventType, "dropped"))
+			records := testutil.ToFloat64(accessKeyRecordsTotal.WithLabelValues("test", unknownEventType))
+			series := testutil.CollectAndCount(eventsTotal) + testutil.CollectAndCount(accessKeyRecordsTotal)
+			body, _ := json.Marshal(validCloudFrontEvent)
+			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			So(err, ShouldBeNil)
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, validCloudFrontEvent.RequestID)
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"made-up-7f3a"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			Convey("Then it should be counted as unknown without adding series", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(testutil.ToFloat64(eventsTotal.WithLabelValues(unknownEventType, "dropped"))-dropped, ShouldEqual, 1)
+				So(testutil.ToFloat64(accessKeyRecordsTotal.WithLabelValues("test", unknownEventType))-records, ShouldEqual, 1)
+				So(testutil.CollectAndCount(eventsTotal)+testutil.CollectAndCount(accessKeyRecordsTotal), ShouldEqual, series)
+			})
+		})
+
 		Convey("When called with a valid cloudfront request", func() {
 			body, _ := json.Marshal(validCloudFrontEvent)
 			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
@@ -153,6 +190,86 @@ func TestFirehoseHandler(t *testing.T) {
 				So(w.Code, ShouldEqual, http.StatusOK)
 			})
 		})
+
+		Convey("When the fluent forwarder is unavailable", func() {
+			factory.NewStub = func() (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			}
+			body, _ := json.Marshal(validCloudwatchLogsEvent)
+			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			So(err, ShouldBeNil)
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, validCloudwatchLogsEvent.RequestID)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"cloudwatchlogs"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			Convey("Then the response status code should be 503", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			})
+			Convey("Then the response should contain the request ID", func() {
+				var resp firehoseResponseBody
+				So(json.NewDecoder(w.Body).Decode(&resp), ShouldBeNil)
+				So(resp.RequestID, ShouldEqual, validCloudwatchLogsEvent.RequestID)
+				So(resp.ErrorMessage, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When the fluent forwarder is unavailable and records are buffered", func() {
+			factory.NewStub = func() (net.Conn, error) {
+				return nil, errors.New("connection refused")
+			}
+			l, err := openWALSet(WALOptions{Dir: t.TempDir()})
+			So(err, ShouldBeNil)
+			wal = l
+			Reset(func() {
+				wal.Close()
+				wal = nil
+			})
+			body, _ := json.Marshal(validCloudwatchLogsEvent)
+			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			So(err, ShouldBeNil)
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, validCloudwatchLogsEvent.RequestID)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"cloudwatchlogs"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			Convey("Then the response status code should be 200", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(factory.NewCallCount(), ShouldEqual, 0)
+				So(wal.logs[""].size, ShouldBeGreaterThan, 0)
+			})
+		})
+
+		Convey("When a request decodes into more messages than a chunk", func() {
+			l, err := openWALSet(WALOptions{Dir: t.TempDir()})
+			So(err, ShouldBeNil)
+			wal = l
+			Reset(func() {
+				wal.Close()
+				wal = nil
+			})
+			event := firehoseRequestBody{RequestID: validCloudFrontEvent.RequestID}
+			for i := 0; i <= requestChunkMessages; i++ {
+				event.Records = append(event.Records, validCloudFrontEvent.Records[0])
+			}
+			body, _ := json.Marshal(event)
+			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			So(err, ShouldBeNil)
+			r.Header.Set(accessKeyHeaderName, testToken)
+			r.Header.Set(requestIDHeaderName, event.RequestID)
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"cloudfront"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			Convey("Then the messages should be buffered in chunks", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				entries := collect(wal.logs[""], nil)
+				So(receive(entries).msgs, ShouldHaveLength, requestChunkMessages)
+				So(receive(entries).msgs, ShouldHaveLength, 1)
+			})
+		})
 	})
 }
 
@@ -193,16 +310,16 @@ func TestParseEventType(t *testing.T) {
 }
 
 func TestFluentbitMessage(t *testing.T) {
-	accessKey = testToken
+	accessKeys = []AccessKey{{Name: "test", Key: testToken}}
 	eventTypeHeaderName = "X-EVENT-TYPE"
-	forwardClient = &fluentclient.Client{
+	forwarder = newForwardConnection("test", &fluentclient.Client{
 		ConnectionFactory: &fluentclient.TCPConnectionFactory{
 			Target: fluentclient.ServerAddress{
 				Hostname: "localhost",
 				Port:     24224,
 			},
 		},
-	}
+	}, 0, 0)
 	Convey("Given fluentbit is running", t, func() {
 		provider, _ := testcontainers.NewDockerProvider()
 		req := testcontainers.ContainerRequest{
@@ -217,7 +334,7 @@ func TestFluentbitMessage(t *testing.T) {
 		defer c.Terminate(context.Background())
 		Convey("It should output a cloudfront log", func() {
 			//logrus.SetLevel(logrus.DebugLevel)
-			err := forwardClient.Connect()
+			err := forwarder.Check()
 			So(err, ShouldBeNil)
 			body, _ := json.Marshal(validCloudFrontEvent)
 			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
@@ -243,7 +360,7 @@ func TestFluentbitMessage(t *testing.T) {
 		})
 		Convey("It should output cloudwatch logs", func() {
 			//logrus.SetLevel(logrus.DebugLevel)
-			err := forwardClient.Connect()
+			err := forwarder.Check()
 			So(err, ShouldBeNil)
 			body, _ := json.Marshal(validCloudwatchLogsEvent)
 			r, err := http.NewRequest("POST", "", bytes.NewBuffer(body))
@@ -0,0 +1,292 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Timestamp formats of forwarded messages.
+const (
+	// TimeFormatEventTime forwards timestamps as the EventTime extension
+	// type with sub-second precision.
+	TimeFormatEventTime = "eventtime"
+	// TimeFormatSeconds forwards timestamps as integer seconds, for older
+	// receivers that do not support EventTime.
+	TimeFormatSeconds = "seconds"
+)
+
+// ForwardOptions configures how messages are sent to the fluent forwarder.
+type ForwardOptions struct {
+	// RequireAck sets a chunk ID on every message and waits for the
+	// receiver to acknowledge it before the firehose request is answered.
+	RequireAck bool
+	// AckTimeout is how long to wait for an acknowledgement.
+	AckTimeout time.Duration
+	// BatchMaxEntries is the maximum number of entries packed into one
+	// PackedForward message. Messages are sent one by one if it is 1 or
+	// less.
+	BatchMaxEntries int
+	// BatchMaxBytes is the maximum size of the event stream of one
+	// PackedForward message. A single entry larger than this is sent in a
+	// batch of its own.
+	BatchMaxBytes int
+	// Compress sends PackedForward messages gzip compressed, as
+	// CompressedPackedForward messages.
+	Compress bool
+	// CompressionLevel is the gzip compression level, see compress/gzip.
+	CompressionLevel int
+	// ReconnectBackoff is the delay before reconnecting after the first
+	// failed connection attempt. It doubles with every further failure.
+	ReconnectBackoff time.Duration
+	// ReconnectMaxBackoff caps the delay between connection attempts.
+	ReconnectMaxBackoff time.Duration
+	// Strategy chooses between multiple upstreams, see StrategyRoundRobin,
+	// StrategyLeastInflight and StrategyFailover. It defaults to
+	// round-robin.
+	Strategy string
+	// HealthCheckInterval is how often upstreams are dialed to update
+	// their health. Periodic checks are disabled if it is zero.
+	HealthCheckInterval time.Duration
+	// TLS configures the connection for tls:// forward addresses.
+	TLS TLSOptions
+	// SharedKey enables the HELO/PING/PONG handshake with the forwarder.
+	SharedKey string
+	// Username and Password are sent in the handshake if the forwarder
+	// requires user authentication.
+	Username string
+	Password string
+	// Hostname is the client hostname sent in the handshake.
+	Hostname string
+	// WAL configures the on-disk buffer in front of the forwarder.
+	WAL WALOptions
+}
+
+// validate checks the options for invalid combinations.
+func (o ForwardOptions) validate() error {
+	if o.Strategy != "" && !validStrategy(o.Strategy) {
+		return fmt.Errorf("unknown upstream strategy: %s", o.Strategy)
+	}
+	if o.SharedKey == "" && (o.Username != "" || o.Password != "") {
+		return fmt.Errorf("username and password require a shared key")
+	}
+	if err := o.WAL.validate(); err != nil {
+		return err
+	}
+	if !o.Compress {
+		return nil
+	}
+	if o.BatchMaxEntries <= 1 {
+		return fmt.Errorf("compression requires batching")
+	}
+	if o.CompressionLevel < gzip.HuffmanOnly || o.CompressionLevel > gzip.BestCompression {
+		return fmt.Errorf("invalid compression level: %d", o.CompressionLevel)
+	}
+	return nil
+}
+
+var (
+	timeFormat     = TimeFormatEventTime
+	forwardOptions ForwardOptions
+	batchEntries   = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "fluenthose_forward_batch_entries",
+			Help:    "Number of entries per PackedForward message",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		},
+	)
+	batchBytes = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "fluenthose_forward_batch_bytes",
+			Help:    "Size of the event stream per PackedForward message",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		},
+	)
+	compressionBytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_forward_compression_bytes_total",
+			Help: "Size of compressed event streams before and after compression",
+		},
+		[]string{"stage"},
+	)
+)
+
+// SetTimeFormat sets the timestamp format of forwarded messages.
+func SetTimeFormat(format string) error {
+	switch format {
+	case TimeFormatEventTime, TimeFormatSeconds:
+		timeFormat = format
+		return nil
+	default:
+		return fmt.Errorf("unknown time format: %s", format)
+	}
+}
+
+// forwardEncoder returns msg in the configured timestamp format.
+func forwardEncoder(msg *protocol.MessageExt) protocol.ChunkEncoder {
+	if timeFormat == TimeFormatSeconds {
+		return &protocol.Message{
+			Tag:       msg.Tag,
+			Timestamp: msg.Timestamp.Unix(),
+			Record:    msg.Record,
+			Options:   msg.Options,
+		}
+	}
+	return msg
+}
+
+// forwardMessages sends the decoded messages of a firehose request to
+// target, packed into batches per tag as configured by opts. It
+// stops at the first message that cannot be sent and returns the error,
+// so the firehose request can be retried.
+func forwardMessages(target forwardTarget, opts ForwardOptions, eventType string, msgs []*protocol.MessageExt) error {
+	if opts.BatchMaxEntries <= 1 {
+		for _, msg := range msgs {
+			log.Debugf("forward message: %+v", msg)
+			if err := sendMessage(target, eventType, forwardEncoder(msg), 1); err != nil {
+				return err
+			}
+		}
+	} else {
+		batches, err := packMessages(msgs, opts)
+		if err != nil {
+			return err
+		}
+		for _, batch := range batches {
+			log.Debugf("forward batch: tag=%s entries=%d bytes=%d", batch.msg.Tag, batch.entries, len(batch.msg.EventStream))
+			batchEntries.Observe(float64(batch.entries))
+			batchBytes.Observe(float64(len(batch.msg.EventStream)))
+			if err := sendMessage(target, eventType, batch.msg, batch.entries); err != nil {
+				return err
+			}
+		}
+	}
+	log.Infof("%d records sent to fluent forwarder", len(msgs))
+	return nil
+}
+
+// sendMessage sends a message holding the given number of entries.
+func sendMessage(target forwardTarget, eventType string, msg protocol.ChunkEncoder, entries int) error {
+	label := metricEventType(eventType)
+	if err := target.Send(msg); err != nil {
+		eventsTotal.WithLabelValues(label, "error").Add(float64(entries))
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	eventsTotal.WithLabelValues(label, "success").Add(float64(entries))
+	return nil
+}
+
+// batch is a PackedForward message and the number of entries it holds.
+type batch struct {
+	msg     *protocol.PackedForwardMessage
+	entries int
+}
+
+// packMessages packs msgs into PackedForward messages per tag, keeping the
+// order of the messages within a tag. Each message holds at most
+// BatchMaxEntries entries and, unless a single entry is larger,
+// BatchMaxBytes of uncompressed event stream.
+func packMessages(msgs []*protocol.MessageExt, opts ForwardOptions) ([]batch, error) {
+	var (
+		tags    []string
+		byTag   = make(map[string][]*protocol.MessageExt)
+		batches []batch
+		entry   bytes.Buffer
+	)
+	for _, msg := range msgs {
+		if _, ok := byTag[msg.Tag]; !ok {
+			tags = append(tags, msg.Tag)
+		}
+		byTag[msg.Tag] = append(byTag[msg.Tag], msg)
+	}
+	for _, tag := range tags {
+		var (
+			stream  []byte
+			entries int
+		)
+		flush := func() error {
+			if entries == 0 {
+				return nil
+			}
+			size := entries
+			options := &protocol.MessageOptions{Size: &size}
+			if opts.Compress {
+				compressed, err := compressStream(stream, opts.CompressionLevel)
+				if err != nil {
+					return err
+				}
+				compressionBytesTotal.WithLabelValues("uncompressed").Add(float64(len(stream)))
+				compressionBytesTotal.WithLabelValues("compressed").Add(float64(len(compressed)))
+				stream = compressed
+				options.Compressed = protocol.OptValGZIP
+			}
+			pfm := protocol.NewPackedForwardMessageFromBytes(tag, stream)
+			pfm.Options = options
+			batches = append(batches, batch{msg: pfm, entries: entries})
+			stream, entries = nil, 0
+			return nil
+		}
+		for _, msg := range byTag[tag] {
+			entry.Reset()
+			if err := encodeEntry(&entry, msg); err != nil {
+				return nil, err
+			}
+			if entries >= opts.BatchMaxEntries || (opts.BatchMaxBytes > 0 && len(stream)+entry.Len() > opts.BatchMaxBytes) {
+				if err := flush(); err != nil {
+					return nil, err
+				}
+			}
+			stream = append(stream, entry.Bytes()...)
+			entries++
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+	}
+	return batches, nil
+}
+
+// compressStream gzip compresses an event stream.
+func compressStream(stream []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(stream); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeEntry writes msg as a forward protocol entry in the configured
+// timestamp format.
+func encodeEntry(w io.Writer, msg *protocol.MessageExt) error {
+	mw := msgp.NewWriter(w)
+	if err := mw.WriteArrayHeader(2); err != nil {
+		return err
+	}
+	var err error
+	if timeFormat == TimeFormatSeconds {
+		err = mw.WriteInt64(msg.Timestamp.Unix())
+	} else {
+		err = mw.WriteExtension(&msg.Timestamp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := mw.WriteIntf(msg.Record); err != nil {
+		return err
+	}
+	return mw.Flush()
+}
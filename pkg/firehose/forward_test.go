@@ -0,0 +1,228 @@
+package firehose
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// encodedTimeType returns the msgpack type of the timestamp of an encoded
+// message.
+func encodedTimeType(e protocol.ChunkEncoder) msgp.Type {
+	var buf bytes.Buffer
+	So(msgp.Encode(&buf, e), ShouldBeNil)
+	r := msgp.NewReader(&buf)
+	_, err := r.ReadArrayHeader()
+	So(err, ShouldBeNil)
+	So(r.Skip(), ShouldBeNil)
+	t, err := r.NextType()
+	So(err, ShouldBeNil)
+	return t
+}
+
+func TestForwardEncoder(t *testing.T) {
+	Convey("Given a message with a sub-second timestamp", t, func() {
+		ts := time.Unix(1600110569, 39*int64(time.Millisecond)).UTC()
+		msg := &protocol.MessageExt{
+			Tag:       "test",
+			Timestamp: protocol.EventTime{Time: ts},
+			Record:    map[string]interface{}{"message": "hello"},
+			Options:   &protocol.MessageOptions{},
+		}
+		Reset(func() {
+			So(SetTimeFormat(TimeFormatEventTime), ShouldBeNil)
+		})
+
+		Convey("When forwarding as EventTime", func() {
+			So(SetTimeFormat(TimeFormatEventTime), ShouldBeNil)
+			e := forwardEncoder(msg)
+			So(encodedTimeType(e), ShouldEqual, msgp.ExtensionType)
+		})
+
+		Convey("When forwarding as integer seconds", func() {
+			So(SetTimeFormat(TimeFormatSeconds), ShouldBeNil)
+			e := forwardEncoder(msg)
+			So(encodedTimeType(e), ShouldEqual, msgp.IntType)
+			So(e.(*protocol.Message).Timestamp, ShouldEqual, int64(1600110569))
+		})
+
+		Convey("When setting an unknown format", func() {
+			So(SetTimeFormat("millis"), ShouldNotBeNil)
+		})
+	})
+}
+
+// ackConnection returns a connection that acknowledges every chunk written
+// to it.
+func ackConnection() (net.Conn, error) {
+	client, server := net.Pipe()
+	go func() {
+		r := msgp.NewReader(server)
+		for {
+			msg, err := r.ReadIntf()
+			if err != nil {
+				server.Close()
+				return
+			}
+			// the options are the last element of every message mode
+			fields := msg.([]interface{})
+			options := fields[len(fields)-1].(map[string]interface{})
+			if err := msgp.Encode(server, &protocol.AckMessage{Ack: options["chunk"].(string)}); err != nil {
+				return
+			}
+		}
+	}()
+	return client, nil
+}
+
+func TestForwardMessagesAck(t *testing.T) {
+	Convey("Given a client requiring acknowledgements", t, func() {
+		factory := &clientfakes.FakeConnectionFactory{}
+		client := &fluentclient.Client{
+			ConnectionFactory: factory,
+			RequireAck:        true,
+			Timeout:           100 * time.Millisecond,
+		}
+		forwarder = newForwardConnection("test", client, 0, 0)
+		Reset(func() {
+			forwarder.Close()
+		})
+		msgs := []*protocol.MessageExt{
+			protocol.NewMessageExt("test", protocol.Record{"message": "one"}),
+			protocol.NewMessageExt("test", protocol.Record{"message": "two"}),
+		}
+
+		Convey("When the receiver acknowledges every batch", func() {
+			factory.NewStub = ackConnection
+			So(forwardMessages(forwarder, ForwardOptions{BatchMaxEntries: 100}, "test", msgs), ShouldBeNil)
+		})
+
+		Convey("When the receiver acknowledges every chunk", func() {
+			factory.NewStub = ackConnection
+			err := forwardMessages(forwarder, ForwardOptions{}, "test", msgs)
+			Convey("Then forwarding should succeed", func() {
+				So(err, ShouldBeNil)
+				So(msgs[0].Options.Chunk, ShouldNotBeEmpty)
+				So(msgs[1].Options.Chunk, ShouldNotEqual, msgs[0].Options.Chunk)
+			})
+		})
+
+		Convey("When the receiver does not acknowledge", func() {
+			factory.NewStub = discardConnection
+			err := forwardMessages(forwarder, ForwardOptions{}, "test", msgs)
+			Convey("Then forwarding should fail", func() {
+				So(err, ShouldNotBeNil)
+			})
+			Convey("Then the connection should be replaced on the next send", func() {
+				So(client.Session, ShouldBeNil)
+				forwardMessages(forwarder, ForwardOptions{}, "test", msgs)
+				So(factory.NewCallCount(), ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+// decodeEventStream decodes the entries of a PackedForward message.
+func decodeEventStream(pfm *protocol.PackedForwardMessage) []protocol.EntryExt {
+	var entries []protocol.EntryExt
+	r := msgp.NewReader(bytes.NewReader(pfm.EventStream))
+	for {
+		var entry protocol.EntryExt
+		if err := entry.DecodeMsg(r); err != nil {
+			return entries
+		}
+		entries = append(entries, entry)
+	}
+}
+
+func TestPackMessages(t *testing.T) {
+	Convey("Given messages with two tags", t, func() {
+		var msgs []*protocol.MessageExt
+		for i := 0; i < 5; i++ {
+			msgs = append(msgs,
+				protocol.NewMessageExt("a", protocol.Record{"n": i}),
+				protocol.NewMessageExt("b", protocol.Record{"n": i}),
+			)
+		}
+
+		Convey("When packing without tight limits", func() {
+			batches, err := packMessages(msgs, ForwardOptions{BatchMaxEntries: 100, BatchMaxBytes: 1024 * 1024})
+			So(err, ShouldBeNil)
+			Convey("Then there should be one batch per tag", func() {
+				So(batches, ShouldHaveLength, 2)
+				So(batches[0].msg.Tag, ShouldEqual, "a")
+				So(batches[0].entries, ShouldEqual, 5)
+				So(*batches[0].msg.Options.Size, ShouldEqual, 5)
+				So(batches[1].msg.Tag, ShouldEqual, "b")
+			})
+			Convey("Then the entries should keep their order and timestamps", func() {
+				entries := decodeEventStream(batches[0].msg)
+				So(entries, ShouldHaveLength, 5)
+				So(entries[0].Timestamp.Time.Equal(msgs[0].Timestamp.Time), ShouldBeTrue)
+				So(entries[4].Record.(map[string]interface{})["n"], ShouldEqual, int64(4))
+			})
+		})
+
+		Convey("When packing with an entry limit", func() {
+			batches, err := packMessages(msgs, ForwardOptions{BatchMaxEntries: 2, BatchMaxBytes: 1024 * 1024})
+			So(err, ShouldBeNil)
+			So(batches, ShouldHaveLength, 6)
+			So(batches[2].msg.Tag, ShouldEqual, "a")
+			So(batches[2].entries, ShouldEqual, 1)
+		})
+
+		Convey("When packing with a size limit", func() {
+			entry := new(bytes.Buffer)
+			So(encodeEntry(entry, msgs[0]), ShouldBeNil)
+			batches, err := packMessages(msgs, ForwardOptions{BatchMaxEntries: 100, BatchMaxBytes: 2 * entry.Len()})
+			So(err, ShouldBeNil)
+			So(batches, ShouldHaveLength, 6)
+			for _, b := range batches {
+				So(len(b.msg.EventStream), ShouldBeLessThanOrEqualTo, 2*entry.Len())
+			}
+		})
+
+		Convey("When packing with compression", func() {
+			before := testutil.ToFloat64(compressionBytesTotal.WithLabelValues("uncompressed"))
+			batches, err := packMessages(msgs, ForwardOptions{
+				BatchMaxEntries:  100,
+				Compress:         true,
+				CompressionLevel: gzip.BestSpeed,
+			})
+			So(err, ShouldBeNil)
+			So(batches, ShouldHaveLength, 2)
+			Convey("Then the event stream should be gzip compressed", func() {
+				So(batches[0].msg.Options.Compressed, ShouldEqual, "gzip")
+				zr, err := gzip.NewReader(bytes.NewReader(batches[0].msg.EventStream))
+				So(err, ShouldBeNil)
+				stream, err := ioutil.ReadAll(zr)
+				So(err, ShouldBeNil)
+				entries := decodeEventStream(protocol.NewPackedForwardMessageFromBytes("a", stream))
+				So(entries, ShouldHaveLength, 5)
+			})
+			Convey("Then the bytes before compression should be counted", func() {
+				So(testutil.ToFloat64(compressionBytesTotal.WithLabelValues("uncompressed")), ShouldBeGreaterThan, before)
+			})
+		})
+	})
+}
+
+func TestForwardOptionsValidate(t *testing.T) {
+	Convey("Compression should require batching", t, func() {
+		So(ForwardOptions{Compress: true, BatchMaxEntries: 1}.validate(), ShouldNotBeNil)
+		So(ForwardOptions{Compress: true, BatchMaxEntries: 10, CompressionLevel: gzip.DefaultCompression}.validate(), ShouldBeNil)
+	})
+	Convey("The compression level should be valid", t, func() {
+		So(ForwardOptions{Compress: true, BatchMaxEntries: 10, CompressionLevel: 10}.validate(), ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,86 @@
+package firehose
+
+import (
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// handshake authenticates a new connection of client with the forward
+// protocol HELO/PING/PONG handshake. It is a no-op without a shared key.
+//
+// Unlike Client.Handshake it sends the username and password when the
+// server asks for them, checks the authentication result and does not
+// block forever on a server that never answers.
+func handshake(client *fluentclient.Client, timeout time.Duration) error {
+	if client.AuthInfo.SharedKey == nil {
+		return nil
+	}
+	conn := client.Session.Connection
+	if timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	r := msgp.NewReader(conn)
+	var helo protocol.Helo
+	if err := helo.DecodeMsg(r); err != nil {
+		return fmt.Errorf("failed to read HELO: %w", err)
+	}
+	if helo.MessageType != protocol.MsgTypeHelo || helo.Options == nil {
+		return errors.New("unexpected handshake message, expected HELO")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	var ping *protocol.Ping
+	var err error
+	if len(helo.Options.Auth) > 0 {
+		ping, err = protocol.NewPingWithAuth(client.Hostname, client.AuthInfo.SharedKey, salt, helo.Options.Nonce,
+			client.AuthInfo.Username, passwordDigest(helo.Options.Auth, client.AuthInfo.Username, client.AuthInfo.Password))
+	} else {
+		ping, err = protocol.NewPing(client.Hostname, client.AuthInfo.SharedKey, salt, helo.Options.Nonce)
+	}
+	if err != nil {
+		return err
+	}
+	if err := msgp.Encode(conn, ping); err != nil {
+		return fmt.Errorf("failed to send PING: %w", err)
+	}
+
+	var pong protocol.Pong
+	if err := pong.DecodeMsg(r); err != nil {
+		return fmt.Errorf("failed to read PONG: %w", err)
+	}
+	if !pong.AuthResult {
+		return fmt.Errorf("forwarder rejected authentication: %s", pong.Reason)
+	}
+	if err := protocol.ValidatePongDigest(&pong, client.AuthInfo.SharedKey, helo.Options.Nonce, salt); err != nil {
+		return errors.New("forwarder shared key digest does not match")
+	}
+	client.Session.TransportPhase = true
+	return nil
+}
+
+// passwordDigest returns the password as sent in a PING, the hex encoded
+// SHA-512 digest of the auth salt of the HELO, the username and the
+// password.
+func passwordDigest(authSalt []byte, username, password string) string {
+	h := sha512.New()
+	h.Write(authSalt)
+	io.WriteString(h, username)
+	io.WriteString(h, password)
+	return hex.EncodeToString(h.Sum(nil))
+}
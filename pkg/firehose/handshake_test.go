@@ -0,0 +1,103 @@
+package firehose
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// handshakeServer returns a connection factory whose connections perform
+// the server side of the forward handshake with sharedKey. If username is
+// set, user authentication is required.
+func handshakeServer(sharedKey, username, password string) func() (net.Conn, error) {
+	return func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			opts := &protocol.HeloOpts{Nonce: []byte("nonce"), Keepalive: true}
+			if username != "" {
+				opts.Auth = []byte("auth-salt")
+			}
+			helo := protocol.NewHelo(opts)
+			if err := msgp.Encode(server, helo); err != nil {
+				return
+			}
+			var ping protocol.Ping
+			if err := msgp.Decode(server, &ping); err != nil {
+				return
+			}
+			authResult, reason := true, ""
+			if err := protocol.ValidatePingDigest(&ping, []byte(sharedKey), opts.Nonce); err != nil {
+				authResult, reason = false, "shared key mismatch"
+			} else if username != "" && (ping.Username != username || ping.Password != passwordDigest(opts.Auth, username, password)) {
+				authResult, reason = false, "username/password mismatch"
+			}
+			pong, _ := protocol.NewPong(authResult, reason, "server", []byte(sharedKey), helo, &ping)
+			if err := msgp.Encode(server, pong); err != nil {
+				return
+			}
+			io.Copy(ioutil.Discard, server)
+		}()
+		return client, nil
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	Convey("Given a client with a shared key", t, func() {
+		factory := &clientfakes.FakeConnectionFactory{}
+		client := &fluentclient.Client{
+			ConnectionFactory: factory,
+			Hostname:          "fluenthose",
+			AuthInfo:          fluentclient.AuthInfo{SharedKey: []byte("secret")},
+		}
+		conn := newForwardConnection("test", client, time.Minute, time.Minute)
+		Reset(func() {
+			conn.Close()
+		})
+		msg := protocol.NewMessageExt("test", protocol.Record{"message": "hello"})
+
+		Convey("When the forwarder has the same key", func() {
+			factory.NewStub = handshakeServer("secret", "", "")
+			So(conn.Send(msg), ShouldBeNil)
+			So(client.Session.TransportPhase, ShouldBeTrue)
+		})
+
+		Convey("When the forwarder has a different key", func() {
+			factory.NewStub = handshakeServer("other", "", "")
+			So(conn.Check(), ShouldNotBeNil)
+			So(client.Session, ShouldBeNil)
+		})
+
+		Convey("When the forwarder requires user authentication", func() {
+			factory.NewStub = handshakeServer("secret", "user", "password")
+			Convey("Then the right credentials should be accepted", func() {
+				client.AuthInfo.Username = "user"
+				client.AuthInfo.Password = "password"
+				So(conn.Check(), ShouldBeNil)
+			})
+			Convey("Then wrong credentials should be rejected", func() {
+				client.AuthInfo.Username = "user"
+				client.AuthInfo.Password = "wrong"
+				err := conn.Check()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "username/password mismatch")
+			})
+		})
+
+		Convey("When the forwarder never sends a HELO", func() {
+			factory.NewStub = discardConnection
+			So(client.Connect(), ShouldBeNil)
+			start := time.Now()
+			So(handshake(client, 50*time.Millisecond), ShouldNotBeNil)
+			So(time.Since(start), ShouldBeLessThan, time.Second)
+		})
+	})
+}
@@ -0,0 +1,219 @@
+package firehose
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerTLSOptions configures TLS for the Firehose endpoint.
+type ServerTLSOptions struct {
+	// CertFile and KeyFile are the certificate chain and key the endpoint
+	// is served with. The endpoint is served over plain HTTP if they are
+	// empty.
+	CertFile string
+	KeyFile  string
+	// MinVersion is the minimum TLS version accepted, 1.2 or 1.3. It
+	// defaults to 1.2.
+	MinVersion string
+	// CipherSuites are the names of the cipher suites accepted with TLS
+	// 1.2, see tls.CipherSuites. The cipher suites of TLS 1.3 are not
+	// configurable. The Go defaults are used if it is empty.
+	CipherSuites []string
+	// ClientCAFile is a PEM bundle of CAs. Firehose requests must present
+	// a certificate signed by one of them if it is set, the health and
+	// metrics endpoints can be used without one.
+	ClientCAFile string
+}
+
+var (
+	errClientCertRequired = &firehoseAPIError{code: http.StatusUnauthorized, msg: "client certificate required"}
+
+	tlsVersions = map[string]uint16{
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	// serverTLS is the TLS configuration of the endpoint, nil if it is
+	// served over plain HTTP.
+	serverTLS *tls.Config
+
+	tlsCertificateExpiry = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_tls_certificate_expiry_timestamp_seconds",
+			Help: "Expiry of the certificate the endpoint is served with",
+		},
+	)
+)
+
+// Enabled reports whether the endpoint is served with TLS.
+func (o ServerTLSOptions) Enabled() bool {
+	return o.CertFile != "" || o.KeyFile != ""
+}
+
+// validate checks the options for invalid combinations, the files are
+// only read by config.
+func (o ServerTLSOptions) validate() error {
+	if !o.Enabled() {
+		if o.ClientCAFile != "" {
+			return fmt.Errorf("client certificates require a TLS certificate")
+		}
+		return nil
+	}
+	if o.CertFile == "" || o.KeyFile == "" {
+		return fmt.Errorf("TLS requires a certificate and a key file")
+	}
+	if _, err := o.minVersion(); err != nil {
+		return err
+	}
+	_, err := o.cipherSuites()
+	return err
+}
+
+func (o ServerTLSOptions) minVersion() (uint16, error) {
+	if o.MinVersion == "" {
+		return tls.VersionTLS12, nil
+	}
+	version, ok := tlsVersions[o.MinVersion]
+	if !ok {
+		return 0, fmt.Errorf("unsupported minimum TLS version: %s", o.MinVersion)
+	}
+	return version, nil
+}
+
+func (o ServerTLSOptions) cipherSuites() ([]uint16, error) {
+	if len(o.CipherSuites) == 0 {
+		return nil, nil
+	}
+	ids := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		ids[suite.Name] = suite.ID
+	}
+	suites := make([]uint16, len(o.CipherSuites))
+	for i, name := range o.CipherSuites {
+		id, ok := ids[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+		suites[i] = id
+	}
+	return suites, nil
+}
+
+// config returns the TLS server configuration for the options, reading
+// the certificate and CA files.
+func (o ServerTLSOptions) config() (*tls.Config, error) {
+	minVersion, err := o.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	suites, err := o.cipherSuites()
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+		NextProtos:   []string{"http/1.1"},
+	}
+	if o.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(o.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.ClientCAFile)
+		}
+		// probes and scrapers connect without a certificate, the
+		// Firehose endpoint requires one with requireClientCert
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// applyServerTLS makes config the TLS configuration of the endpoint. The
+// caller must hold the write lock of configMu unless the server is not
+// running yet.
+func applyServerTLS(config *tls.Config) {
+	serverTLS = config
+	if config != nil {
+		tlsCertificateExpiry.Set(float64(config.Certificates[0].Leaf.NotAfter.Unix()))
+	}
+}
+
+// currentServerTLS returns the TLS configuration for a new connection, so
+// reloaded certificates and settings apply to connections made after the
+// reload.
+func currentServerTLS(*tls.ClientHelloInfo) (*tls.Config, error) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return serverTLS, nil
+}
+
+// requireClientCert rejects requests without a verified client certificate
+// before they are passed to next, if client CAs are configured.
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		configMu.RLock()
+		required := serverTLS != nil && serverTLS.ClientCAs != nil
+		configMu.RUnlock()
+		if required && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			log.Warnf("rejected request from %s without a client certificate", r.RemoteAddr)
+			JSONHandleError(w, errClientCertRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// proxyHeaderPolicy accepts PROXY protocol headers from the trusted
+// proxies, or from everywhere if there are none, which validate only
+// allows without an allowlist. Connections of other sources sending a
+// header are rejected. The header is optional, so probes can connect
+// directly.
+func proxyHeaderPolicy(upstream net.Addr) (proxyproto.Policy, error) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	if len(trustedProxies) == 0 {
+		return proxyproto.USE, nil
+	}
+	if addr, ok := upstream.(*net.TCPAddr); ok && containsIP(trustedProxies, addr.IP) {
+		return proxyproto.USE, nil
+	}
+	// an error would stop the server from accepting connections
+	return proxyproto.REJECT, nil
+}
+
+// listen returns the listener of the Firehose endpoint configured by
+// config. It reads PROXY protocol headers before TLS is terminated, so the
+// remote address of requests is the address of the client rather than
+// the one of the load balancer.
+func listen(address string, config Config) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config.ProxyProtocol {
+		ln = &proxyproto.Listener{Listener: ln, Policy: proxyHeaderPolicy}
+	}
+	if config.TLS.Enabled() {
+		ln = tls.NewListener(ln, &tls.Config{GetConfigForClient: currentServerTLS})
+	}
+	return ln, nil
+}
@@ -0,0 +1,221 @@
+package firehose
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	proxyproto "github.com/pires/go-proxyproto"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// applyListenerConfig applies config and tlsConfig like Reload, as the
+// server may be accepting connections.
+func applyListenerConfig(config Config, target forwardTarget, tlsConfig *tls.Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	applyConfig(config, target, nil)
+	applyServerTLS(tlsConfig)
+}
+
+func TestServerTLSOptions(t *testing.T) {
+	Convey("TLS options should be validated", t, func() {
+		So(ServerTLSOptions{}.validate(), ShouldBeNil)
+		So(ServerTLSOptions{CertFile: "a", KeyFile: "b", MinVersion: "1.3"}.validate(), ShouldBeNil)
+		So(ServerTLSOptions{CertFile: "a"}.validate(), ShouldNotBeNil)
+		So(ServerTLSOptions{ClientCAFile: "a"}.validate(), ShouldNotBeNil)
+		So(ServerTLSOptions{CertFile: "a", KeyFile: "b", MinVersion: "1.0"}.validate(), ShouldNotBeNil)
+		So(ServerTLSOptions{CertFile: "a", KeyFile: "b", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}.validate(), ShouldNotBeNil)
+		So(ServerTLSOptions{CertFile: "a", KeyFile: "b", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}.validate(), ShouldBeNil)
+	})
+}
+
+func TestTLSListener(t *testing.T) {
+	Convey("Given an endpoint served with TLS", t, func() {
+		dir := t.TempDir()
+		ca := writeCertificate(dir, "ca", 1, nil)
+		writeCertificate(dir, "server", 2, &ca)
+		opts := ServerTLSOptions{
+			CertFile: filepath.Join(dir, "server.crt"),
+			KeyFile:  filepath.Join(dir, "server.key"),
+		}
+		config := Config{AccessKeys: []AccessKey{{Name: "test", Key: testToken}}, TLS: opts}
+		tlsConfig, err := opts.config()
+		So(err, ShouldBeNil)
+		applyListenerConfig(config, &closeTarget{}, tlsConfig)
+		ln, err := listen("127.0.0.1:0", config)
+		So(err, ShouldBeNil)
+		mux := http.NewServeMux()
+		mux.Handle("/", requireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+		mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+		srv := &http.Server{Handler: mux}
+		go srv.Serve(ln)
+		Reset(func() {
+			srv.Close()
+			applyListenerConfig(Config{}, nil, nil)
+		})
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.Leaf)
+		dial := func(client *tls.Config) (*tls.Conn, error) {
+			client.RootCAs = roots
+			client.ServerName = "localhost"
+			return tls.Dial("tcp", ln.Addr().String(), client)
+		}
+		load := func() (Config, error) {
+			return config, nil
+		}
+
+		Convey("Requests should be served", func() {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "localhost"}}}
+			resp, err := client.Get("https://" + ln.Addr().String())
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+			So(resp.StatusCode, ShouldEqual, http.StatusOK)
+		})
+
+		Convey("A rotated certificate should be served after a reload", func() {
+			writeCertificate(dir, "server", 3, &ca)
+			So(Reload(load), ShouldBeNil)
+			conn, err := dial(&tls.Config{})
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), ShouldEqual, 3)
+		})
+
+		Convey("A broken certificate should keep the previous one", func() {
+			So(ioutil.WriteFile(opts.KeyFile, []byte("broken"), 0600), ShouldBeNil)
+			So(Reload(load), ShouldNotBeNil)
+			conn, err := dial(&tls.Config{})
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			So(conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(), ShouldEqual, 2)
+		})
+
+		Convey("Older versions than the minimum should be rejected", func() {
+			config.TLS.MinVersion = "1.3"
+			So(Reload(load), ShouldBeNil)
+			_, err := dial(&tls.Config{MaxVersion: tls.VersionTLS12})
+			So(err, ShouldNotBeNil)
+			conn, err := dial(&tls.Config{})
+			So(err, ShouldBeNil)
+			conn.Close()
+		})
+
+		Convey("Firehose requests should present a certificate if client CAs are set", func() {
+			client := writeCertificate(dir, "client", 4, &ca)
+			other := writeCertificate(dir, "other", 5, nil)
+			config.TLS.ClientCAFile = filepath.Join(dir, "ca.crt")
+			So(Reload(load), ShouldBeNil)
+			// get presents cert even if it is not signed by the client CAs
+			get := func(path string, cert tls.Certificate) (int, error) {
+				client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+					RootCAs:    roots,
+					ServerName: "localhost",
+					GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+						return &cert, nil
+					},
+				}}}
+				resp, err := client.Get("https://" + ln.Addr().String() + path)
+				if err != nil {
+					return 0, err
+				}
+				resp.Body.Close()
+				return resp.StatusCode, nil
+			}
+			status, err := get("/", tls.Certificate{})
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusUnauthorized)
+			status, err = get("/", client)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, http.StatusOK)
+			_, err = get("/", other)
+			So(err, ShouldNotBeNil)
+
+			Convey("Probes should connect without one", func() {
+				status, err := get("/health", tls.Certificate{})
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	Convey("Given an endpoint accepting PROXY protocol headers", t, func() {
+		config := Config{ProxyProtocol: true}
+		applyListenerConfig(config, nil, nil)
+		ln, err := listen("127.0.0.1:0", config)
+		So(err, ShouldBeNil)
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.RemoteAddr))
+		})}
+		go srv.Serve(ln)
+		Reset(func() {
+			srv.Close()
+			applyListenerConfig(Config{}, nil, nil)
+		})
+		// remoteAddr sends a request preceded by header and returns the
+		// remote address the server saw, or the status if it was rejected
+		remoteAddr := func(header *proxyproto.Header) (string, error) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			if header != nil {
+				_, err := header.WriteTo(conn)
+				So(err, ShouldBeNil)
+			}
+			_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+			So(err, ShouldBeNil)
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if err != nil {
+				return "", err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return "", errors.New(resp.Status)
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			return string(body), err
+		}
+		header := func(version byte) *proxyproto.Header {
+			return &proxyproto.Header{
+				Version:           version,
+				Command:           proxyproto.PROXY,
+				TransportProtocol: proxyproto.TCPv4,
+				SourceAddr:        &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4711},
+				DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443},
+			}
+		}
+
+		Convey("The client address of v1 and v2 headers should be used", func() {
+			addr, err := remoteAddr(header(1))
+			So(err, ShouldBeNil)
+			So(addr, ShouldEqual, "192.0.2.1:4711")
+			addr, err = remoteAddr(header(2))
+			So(err, ShouldBeNil)
+			So(addr, ShouldEqual, "192.0.2.1:4711")
+		})
+
+		Convey("Connections without a header should be accepted", func() {
+			addr, err := remoteAddr(nil)
+			So(err, ShouldBeNil)
+			So(addr, ShouldStartWith, "127.0.0.1:")
+		})
+
+		Convey("Headers should only be accepted from trusted proxies", func() {
+			applyListenerConfig(Config{ProxyProtocol: true, TrustedProxies: []string{"10.0.0.0/8"}}, nil, nil)
+			_, err := remoteAddr(header(2))
+			So(err, ShouldNotBeNil)
+			applyListenerConfig(Config{ProxyProtocol: true, TrustedProxies: []string{"127.0.0.1"}}, nil, nil)
+			addr, err := remoteAddr(header(2))
+			So(err, ShouldBeNil)
+			So(addr, ShouldEqual, "192.0.2.1:4711")
+		})
+	})
+}
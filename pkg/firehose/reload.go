@@ -0,0 +1,294 @@
+package firehose
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of the server that can be reloaded while it
+// is running.
+type Config struct {
+	// AccessKeys are the keys Firehose authenticates requests with.
+	AccessKeys []AccessKey
+	// EventTypeHeader is the common attribute naming the event type.
+	EventTypeHeader string
+	// ForwardAddresses are the upstreams records are forwarded to.
+	ForwardAddresses []string
+	// Forward configures how records are forwarded. The write-ahead log
+	// is only configured on start.
+	Forward ForwardOptions
+	// Tenants are routed by the access keys naming them.
+	Tenants []Tenant
+	// AllowedCIDRs are the networks requests are accepted from, from
+	// everywhere if it is empty.
+	AllowedCIDRs []string
+	// TrustedProxies are the networks of load balancers whose
+	// X-Forwarded-For header is used to determine the source of requests.
+	TrustedProxies []string
+	// ProxyProtocol reads PROXY protocol v1 and v2 headers of the trusted
+	// proxies, or of all sources if there are none. It requires trusted
+	// proxies together with AllowedCIDRs. It is only enabled or disabled on
+	// start.
+	ProxyProtocol bool
+	// TLS configures TLS for the Firehose endpoint. It is only enabled or
+	// disabled on start, the certificate and settings are reloaded.
+	TLS ServerTLSOptions
+}
+
+var (
+	// configMu guards the settings replaced by Reload. Requests and the
+	// write-ahead log only hold a read lock while they take a snapshot of
+	// them, so slow upstreams do not hold up a reload.
+	configMu sync.RWMutex
+	// currentConfig is the configuration applied last.
+	currentConfig Config
+
+	// targetsMu guards targetUsers and retiredTargets.
+	targetsMu sync.Mutex
+	// targetUsers counts the requests and drains forwarding to a target.
+	targetUsers = make(map[forwardTarget]int)
+	// retiredTargets were replaced by a reload while they were in use,
+	// they are closed once their last user releases them.
+	retiredTargets = make(map[forwardTarget]bool)
+
+	reloadsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_config_reloads_total",
+			Help: "Number of configuration reloads by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// validate checks the configuration before it is applied.
+func (c Config) validate() error {
+	if err := validateAccessKeys(c.AccessKeys); err != nil {
+		return err
+	}
+	if err := validateTenants(c.Tenants, c.AccessKeys); err != nil {
+		return err
+	}
+	if err := c.Forward.validate(); err != nil {
+		return fmt.Errorf("invalid forward options: %w", err)
+	}
+	if _, err := parseNetworks(c.AllowedCIDRs); err != nil {
+		return fmt.Errorf("invalid allowed CIDRs: %w", err)
+	}
+	if _, err := parseNetworks(c.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	if c.ProxyProtocol && len(c.AllowedCIDRs) > 0 && len(c.TrustedProxies) == 0 {
+		// any client could claim an allowed address in a PROXY header
+		return fmt.Errorf("PROXY protocol with allowed CIDRs requires trusted proxies")
+	}
+	if err := c.TLS.validate(); err != nil {
+		return fmt.Errorf("invalid TLS options: %w", err)
+	}
+	return nil
+}
+
+// sameForwarder reports whether c and other forward to the same upstreams
+// the same way, so the connections can be kept.
+func (c Config) sameForwarder(other Config) bool {
+	return strings.Join(c.ForwardAddresses, ",") == strings.Join(other.ForwardAddresses, ",") && c.Forward == other.Forward
+}
+
+// applyConfig makes config, target and routes current. The configuration
+// must be valid. The caller must hold the write lock of configMu unless
+// the server is not running yet.
+func applyConfig(config Config, target forwardTarget, routes map[string]*tenantRoute) {
+	currentConfig = config
+	accessKeys = config.AccessKeys
+	eventTypeHeaderName = config.EventTypeHeader
+	forwardOptions = config.Forward
+	forwarder = target
+	tenants = routes
+	allowedNetworks, _ = parseNetworks(config.AllowedCIDRs)
+	trustedProxies, _ = parseNetworks(config.TrustedProxies)
+}
+
+// Reload loads the configuration with load and replaces the current one.
+// Requests in flight complete with the previous configuration, replaced
+// connections are closed once they are done. The
+// connections to the forwarder are only replaced if the forward addresses
+// or options changed. The TLS certificate is read again, new connections
+// are served with it. The configuration is kept if load fails or returns
+// an invalid configuration.
+func Reload(load func() (Config, error)) error {
+	if err := reload(load); err != nil {
+		reloadsTotal.WithLabelValues("failure").Inc()
+		return err
+	}
+	reloadsTotal.WithLabelValues("success").Inc()
+	return nil
+}
+
+func reload(load func() (Config, error)) error {
+	config, err := load()
+	if err != nil {
+		return err
+	}
+	configMu.RLock()
+	previous := currentConfig
+	if config.Forward.WAL != previous.Forward.WAL {
+		log.Warnf("write-ahead log settings are only applied on restart")
+		config.Forward.WAL = previous.Forward.WAL
+	}
+	if config.ProxyProtocol != previous.ProxyProtocol {
+		log.Warnf("PROXY protocol is only enabled or disabled on restart")
+		config.ProxyProtocol = previous.ProxyProtocol
+	}
+	if config.TLS.Enabled() != previous.TLS.Enabled() {
+		log.Warnf("TLS is only enabled or disabled on restart")
+		config.TLS = previous.TLS
+	}
+	previousRoutes := tenants
+	configMu.RUnlock()
+	// validated with the settings kept from the previous configuration,
+	// as they are the ones applied
+	if err := config.validate(); err != nil {
+		return err
+	}
+	var tlsConfig *tls.Config
+	if config.TLS.Enabled() {
+		tlsConfig, err = config.TLS.config()
+		if err != nil {
+			return fmt.Errorf("failed to load TLS settings: %w", err)
+		}
+	}
+	var pool forwardTarget
+	if !config.sameForwarder(previous) {
+		pool, err = newForwarder(config.ForwardAddresses, config.Forward)
+		if err != nil {
+			return err
+		}
+	}
+	routes, stale, err := newTenantRoutes(config, previousRoutes, previous.Forward)
+	if err != nil {
+		if pool != nil {
+			pool.Close()
+		}
+		return err
+	}
+	configMu.Lock()
+	old := forwarder
+	if pool == nil {
+		pool = old
+	}
+	applyConfig(config, pool, routes)
+	applyServerTLS(tlsConfig)
+	configMu.Unlock()
+	if old != nil && old != pool {
+		stale = append(stale, old)
+		log.Infof("forwarding to %s", strings.Join(config.ForwardAddresses, ", "))
+	}
+	closeTargets(stale)
+	return nil
+}
+
+// closeTargets closes forward targets that are no longer in use. Targets
+// that are still in use are closed by releaseTarget once they are not.
+func closeTargets(targets []forwardTarget) {
+	for _, target := range targets {
+		targetsMu.Lock()
+		inUse := targetUsers[target] > 0
+		if inUse {
+			retiredTargets[target] = true
+		}
+		targetsMu.Unlock()
+		if !inUse {
+			target.Close()
+		}
+	}
+}
+
+// acquireTarget keeps target from being closed by a reload until it is
+// released. The caller must hold a read lock of configMu, so that target
+// is not retired before it is acquired.
+func acquireTarget(target forwardTarget) {
+	if target == nil {
+		return
+	}
+	targetsMu.Lock()
+	defer targetsMu.Unlock()
+	targetUsers[target]++
+}
+
+// releaseTarget releases a target acquired with acquireTarget and closes
+// it if it was retired and this was its last user.
+func releaseTarget(target forwardTarget) {
+	if target == nil {
+		return
+	}
+	targetsMu.Lock()
+	targetUsers[target]--
+	closing := false
+	if targetUsers[target] <= 0 {
+		delete(targetUsers, target)
+		closing = retiredTargets[target]
+		delete(retiredTargets, target)
+	}
+	targetsMu.Unlock()
+	if closing {
+		target.Close()
+	}
+}
+
+// forwardSnapshot is the target and forward options messages of a tenant
+// are forwarded with, taken from the configuration current at the time.
+type forwardSnapshot struct {
+	target forwardTarget
+	opts   ForwardOptions
+}
+
+// snapshotForward returns the current forward snapshot of tenant and
+// acquires its target. The caller must hold a read lock of configMu and
+// release the snapshot when it is done.
+func snapshotForward(tenant string) (forwardSnapshot, error) {
+	target, err := targetFor(tenant)
+	if err != nil {
+		return forwardSnapshot{}, err
+	}
+	acquireTarget(target)
+	return forwardSnapshot{target: target, opts: forwardOptions}, nil
+}
+
+// forward forwards msgs like forwardMessages.
+func (s forwardSnapshot) forward(eventType string, msgs []*protocol.MessageExt) error {
+	return forwardMessages(s.target, s.opts, eventType, msgs)
+}
+
+// release releases the target of the snapshot.
+func (s forwardSnapshot) release() {
+	releaseTarget(s.target)
+}
+
+// forwardCurrent forwards msgs of tenant like forwardMessages with the
+// current configuration, for callers outside of a request.
+func forwardCurrent(tenant, eventType string, msgs []*protocol.MessageExt) error {
+	configMu.RLock()
+	snapshot, err := snapshotForward(tenant)
+	configMu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer snapshot.release()
+	return snapshot.forward(eventType, msgs)
+}
+
+// checkForwarder checks the current forwarder, it is the readiness check
+// if records are not buffered.
+func checkForwarder() error {
+	configMu.RLock()
+	target := forwarder
+	acquireTarget(target)
+	configMu.RUnlock()
+	defer releaseTarget(target)
+	return target.Check()
+}
@@ -0,0 +1,183 @@
+package firehose
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// closeTarget is a forward target recording whether it was closed.
+type closeTarget struct {
+	closed bool
+}
+
+func (t *closeTarget) Send(msg protocol.ChunkEncoder) error { return nil }
+func (t *closeTarget) Check() error                         { return nil }
+func (t *closeTarget) Close()                               { t.closed = true }
+
+// checkingTarget is a forward target whose check blocks until done is
+// closed.
+type checkingTarget struct {
+	closeTarget
+	checking chan struct{}
+	done     chan struct{}
+}
+
+func (t *checkingTarget) Check() error {
+	close(t.checking)
+	<-t.done
+	return nil
+}
+
+func TestReload(t *testing.T) {
+	Convey("Given a running configuration", t, func() {
+		config := Config{
+			AccessKeys:       []AccessKey{{Name: "old", Key: "old"}},
+			EventTypeHeader:  "X-EVENT-TYPE",
+			ForwardAddresses: []string{"127.0.0.1:1"},
+			Forward:          ForwardOptions{WAL: WALOptions{Dir: "/var/lib/fluenthose"}},
+		}
+		target := &closeTarget{}
+		applyConfig(config, target, nil)
+		Reset(func() {
+			applyConfig(Config{}, nil, nil)
+		})
+		successes := testutil.ToFloat64(reloadsTotal.WithLabelValues("success"))
+		failures := testutil.ToFloat64(reloadsTotal.WithLabelValues("failure"))
+		load := func(c Config) func() (Config, error) {
+			return func() (Config, error) {
+				return c, nil
+			}
+		}
+
+		Convey("When the access key is rotated", func() {
+			rotated := config
+			rotated.AccessKeys = []AccessKey{{Name: "new", Key: "new"}}
+			So(Reload(load(rotated)), ShouldBeNil)
+			Convey("Then the new key should be used", func() {
+				So(accessKeys[0].Key, ShouldEqual, "new")
+				So(testutil.ToFloat64(reloadsTotal.WithLabelValues("success"))-successes, ShouldEqual, 1)
+			})
+			Convey("Then the forwarder should be kept", func() {
+				So(forwarder, ShouldEqual, target)
+				So(target.closed, ShouldBeFalse)
+			})
+		})
+
+		Convey("When the forward addresses change", func() {
+			changed := config
+			changed.ForwardAddresses = []string{"127.0.0.1:2"}
+			So(Reload(load(changed)), ShouldBeNil)
+			Reset(func() {
+				forwarder.Close()
+			})
+			Convey("Then the forwarder should be replaced", func() {
+				So(forwarder, ShouldNotEqual, target)
+				So(target.closed, ShouldBeTrue)
+			})
+		})
+
+		Convey("When the write-ahead log settings change", func() {
+			changed := config
+			changed.Forward.WAL.Dir = "/tmp"
+			So(Reload(load(changed)), ShouldBeNil)
+			Convey("Then they should be kept until a restart", func() {
+				So(currentConfig.Forward.WAL.Dir, ShouldEqual, "/var/lib/fluenthose")
+				So(forwarder, ShouldEqual, target)
+			})
+		})
+
+		Convey("When PROXY protocol is enabled", func() {
+			config.ProxyProtocol = true
+			applyConfig(config, target, nil)
+			Convey("Then an allowlist should require trusted proxies", func() {
+				changed := config
+				changed.AllowedCIDRs = []string{"192.0.2.0/24"}
+				So(Reload(load(changed)), ShouldNotBeNil)
+				// PROXY protocol stays enabled until a restart
+				changed.ProxyProtocol = false
+				So(Reload(load(changed)), ShouldNotBeNil)
+				changed.TrustedProxies = []string{"10.0.0.0/8"}
+				So(Reload(load(changed)), ShouldBeNil)
+				So(allowedNetworks, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When loading fails", func() {
+			err := Reload(func() (Config, error) {
+				return Config{}, errors.New("broken config")
+			})
+			Convey("Then the configuration should be kept", func() {
+				So(err, ShouldNotBeNil)
+				So(accessKeys, ShouldResemble, config.AccessKeys)
+				So(testutil.ToFloat64(reloadsTotal.WithLabelValues("failure"))-failures, ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the new configuration is invalid", func() {
+			invalid := config
+			invalid.AccessKeys = nil
+			So(Reload(load(invalid)), ShouldNotBeNil)
+			So(accessKeys, ShouldResemble, config.AccessKeys)
+		})
+
+		Convey("When a request is in flight", func() {
+			configMu.RLock()
+			snapshot, err := snapshotForward("")
+			configMu.RUnlock()
+			So(err, ShouldBeNil)
+			changed := config
+			changed.ForwardAddresses = []string{"127.0.0.1:2"}
+			So(Reload(load(changed)), ShouldBeNil)
+			Reset(func() {
+				forwarder.Close()
+			})
+			Convey("Then the reload should not wait for it to complete", func() {
+				So(forwarder, ShouldNotEqual, target)
+				So(snapshot.target, ShouldEqual, target)
+				snapshot.release()
+			})
+			Convey("Then the previous forwarder should be closed once it completes", func() {
+				So(target.closed, ShouldBeFalse)
+				So(snapshot.forward("test", nil), ShouldBeNil)
+				snapshot.release()
+				So(target.closed, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a readiness check is in flight", func() {
+			checking := &checkingTarget{checking: make(chan struct{}), done: make(chan struct{})}
+			applyConfig(config, checking, nil)
+			checked := make(chan error, 1)
+			go func() {
+				checked <- checkForwarder()
+			}()
+			<-checking.checking
+			changed := config
+			changed.ForwardAddresses = []string{"127.0.0.1:2"}
+			reloaded := make(chan error, 1)
+			go func() {
+				reloaded <- Reload(load(changed))
+			}()
+			Reset(func() {
+				forwarder.Close()
+			})
+			Convey("Then the reload should not wait for it to complete", func() {
+				select {
+				case err := <-reloaded:
+					So(err, ShouldBeNil)
+				case <-time.After(5 * time.Second):
+					So("reload blocked by the check", ShouldBeEmpty)
+				}
+				So(checking.closed, ShouldBeFalse)
+				close(checking.done)
+				So(<-checked, ShouldBeNil)
+				So(checking.closed, ShouldBeTrue)
+			})
+		})
+	})
+}
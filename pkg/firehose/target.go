@@ -0,0 +1,125 @@
+package firehose
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+)
+
+const dialTimeout = 5 * time.Second
+
+// TLSOptions configures TLS for the connection to the forwarder.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of the CAs to verify the forwarder with. The
+	// system roots are used if it is empty.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key presented to
+	// the forwarder.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name the forwarder certificate is verified
+	// against, which defaults to the host of the forward address.
+	ServerName string
+	// InsecureSkipVerify disables verification of the forwarder
+	// certificate. It is meant for tests only.
+	InsecureSkipVerify bool
+}
+
+// config returns the TLS client configuration for the options.
+func (o TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// tlsConnectionFactory creates TLS connections to the forwarder.
+type tlsConnectionFactory struct {
+	Address string
+	Config  *tls.Config
+}
+
+func (f *tlsConnectionFactory) New() (net.Conn, error) {
+	return tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", f.Address, f.Config)
+}
+
+// unixConnectionFactory creates connections to a forwarder listening on a
+// Unix domain socket, e.g. a fluent-bit sidecar in the same pod.
+type unixConnectionFactory struct {
+	Path string
+}
+
+func (f *unixConnectionFactory) New() (net.Conn, error) {
+	return net.DialTimeout("unix", f.Path, dialTimeout)
+}
+
+// newConnectionFactory returns the connection factory for a forward
+// address. The address is either host:port or a URL with a tcp or tls
+// scheme, e.g. tls://aggregator:24224, or the unix scheme with the path of
+// a socket, e.g. unix:///var/run/fluent-bit.sock.
+func newConnectionFactory(address string, tlsOpts TLSOptions) (fluentclient.ConnectionFactory, error) {
+	scheme := "tcp"
+	if i := strings.Index(address, "://"); i >= 0 {
+		scheme, address = address[:i], address[i+3:]
+	}
+	if scheme == "unix" {
+		if address == "" {
+			return nil, fmt.Errorf("missing forward socket path")
+		}
+		return &unixConnectionFactory{Path: address}, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse forward address: %w", err)
+	}
+	switch scheme {
+	case "tcp":
+		portInt, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid forward port: %s", port)
+		}
+		return &fluentclient.TCPConnectionFactory{
+			Target: fluentclient.ServerAddress{
+				Hostname: host,
+				Port:     portInt,
+			},
+		}, nil
+	case "tls":
+		config, err := tlsOpts.config()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load forward TLS settings: %w", err)
+		}
+		if config.ServerName == "" {
+			config.ServerName = host
+		}
+		return &tlsConnectionFactory{Address: address, Config: config}, nil
+	default:
+		return nil, fmt.Errorf("unsupported forward address scheme: %s", scheme)
+	}
+}
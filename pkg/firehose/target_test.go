@@ -0,0 +1,186 @@
+package firehose
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeCertificate writes a certificate for localhost signed by parent,
+// or a self-signed CA if parent is nil, and its key to dir as name.crt and
+// name.key and returns it.
+func writeCertificate(dir, name string, serial int64, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	So(err, ShouldBeNil)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	So(err, ShouldBeNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	So(err, ShouldBeNil)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	So(ioutil.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0600), ShouldBeNil)
+	So(ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600), ShouldBeNil)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	So(err, ShouldBeNil)
+	cert.Leaf, err = x509.ParseCertificate(der)
+	So(err, ShouldBeNil)
+	return cert
+}
+
+func TestNewConnectionFactory(t *testing.T) {
+	Convey("Plain addresses should use TCP", t, func() {
+		for _, address := range []string{"127.0.0.1:24224", "tcp://127.0.0.1:24224"} {
+			factory, err := newConnectionFactory(address, TLSOptions{})
+			So(err, ShouldBeNil)
+			So(factory.(*fluentclient.TCPConnectionFactory).Target.Port, ShouldEqual, 24224)
+		}
+	})
+
+	Convey("Given a forwarder listening on a Unix socket", t, func() {
+		path := filepath.Join(t.TempDir(), "forward.sock")
+		listener, err := net.Listen("unix", path)
+		So(err, ShouldBeNil)
+		Reset(func() {
+			listener.Close()
+		})
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				conn.Close()
+			}
+		}()
+
+		Convey("The unix scheme should dial the socket", func() {
+			factory, err := newConnectionFactory("unix://"+path, TLSOptions{})
+			So(err, ShouldBeNil)
+			So(factory.(*unixConnectionFactory).Path, ShouldEqual, path)
+			conn, err := factory.New()
+			So(err, ShouldBeNil)
+			conn.Close()
+		})
+
+		Convey("The readiness check should fail once the socket is gone", func() {
+			pool, err := newForwarder([]string{"unix://" + path}, ForwardOptions{})
+			So(err, ShouldBeNil)
+			So(pool.Check(), ShouldBeNil)
+			pool.Close()
+			listener.Close()
+			pool, err = newForwarder([]string{"unix://" + path}, ForwardOptions{})
+			So(err, ShouldBeNil)
+			So(pool.Check(), ShouldNotBeNil)
+			pool.Close()
+		})
+	})
+
+	Convey("Invalid addresses should be rejected", t, func() {
+		for _, address := range []string{"127.0.0.1", "udp://127.0.0.1:24224", "127.0.0.1:forward", "unix://"} {
+			_, err := newConnectionFactory(address, TLSOptions{})
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Given a TLS forwarder requiring client certificates", t, func() {
+		dir := t.TempDir()
+		cert := writeCertificate(dir, "localhost", 1, nil)
+		certFile, keyFile := filepath.Join(dir, "localhost.crt"), filepath.Join(dir, "localhost.key")
+		pool, err := TLSOptions{CAFile: certFile}.config()
+		So(err, ShouldBeNil)
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			ClientCAs:    pool.RootCAs,
+		})
+		So(err, ShouldBeNil)
+		Reset(func() {
+			listener.Close()
+		})
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				go func(conn net.Conn) {
+					defer conn.Close()
+					conn.(*tls.Conn).Handshake()
+					conn.Write([]byte("ok"))
+				}(conn)
+			}
+		}()
+		_, port, _ := net.SplitHostPort(listener.Addr().String())
+		address := "tls://localhost:" + port
+
+		// dial returns the first bytes the forwarder sends over the
+		// connection
+		dial := func(opts TLSOptions) (string, error) {
+			factory, err := newConnectionFactory(address, opts)
+			So(err, ShouldBeNil)
+			conn, err := factory.New()
+			if err != nil {
+				return "", err
+			}
+			defer conn.Close()
+			buf := make([]byte, 2)
+			_, err = conn.Read(buf)
+			return string(buf), err
+		}
+
+		Convey("When connecting with the CA and a client certificate", func() {
+			data, err := dial(TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+			So(err, ShouldBeNil)
+			So(data, ShouldEqual, "ok")
+		})
+
+		Convey("When connecting without the CA", func() {
+			_, err := dial(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When connecting with insecure-skip-verify", func() {
+			data, err := dial(TLSOptions{CertFile: certFile, KeyFile: keyFile, InsecureSkipVerify: true})
+			So(err, ShouldBeNil)
+			So(data, ShouldEqual, "ok")
+		})
+
+		Convey("When connecting with the wrong server name", func() {
+			_, err := dial(TLSOptions{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "aggregator"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("When connecting without a client certificate", func() {
+			_, err := dial(TLSOptions{CAFile: certFile})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,189 @@
+package firehose
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+var (
+	errRateLimited = &firehoseAPIError{code: http.StatusTooManyRequests, msg: "rate limit exceeded"}
+
+	// tenants are the routes of the configured tenants by name.
+	tenants map[string]*tenantRoute
+
+	tenantRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_tenant_requests_total",
+			Help: "Number of requests by tenant and status",
+		},
+		[]string{"tenant", "status"},
+	)
+)
+
+// Tenant is a team delivering into fluenthose, identified by the access
+// keys naming it. The records of a tenant are kept apart from those of
+// other tenants by their tag prefix and upstreams.
+type Tenant struct {
+	// Name identifies the tenant in access keys and metrics.
+	Name string
+	// TagPrefix is prepended to the tags of the tenant's records,
+	// separated by a dot.
+	TagPrefix string
+	// ForwardAddresses are the upstreams of the tenant. The records are
+	// forwarded to the default upstreams if it is empty.
+	ForwardAddresses []string
+	// EventTypes are the event types the tenant may deliver. All event
+	// types are allowed if it is empty.
+	EventTypes []string
+	// RequestsPerSecond limits the rate of requests of the tenant, with
+	// bursts of up to Burst requests. The rate is not limited if it is
+	// zero.
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// tenantRoute is a tenant with its upstreams and rate limiter.
+type tenantRoute struct {
+	Tenant
+	// forwarder is nil if the records are forwarded to the default
+	// upstreams.
+	forwarder forwardTarget
+	// limiter is nil if the rate is not limited.
+	limiter *rate.Limiter
+}
+
+// validateTenants checks that the tenants are uniquely named and that
+// every access key names a known tenant.
+func validateTenants(tenants []Tenant, keys []AccessKey) error {
+	names := make(map[string]bool, len(tenants))
+	for _, tenant := range tenants {
+		if tenant.Name == "" {
+			return fmt.Errorf("tenant without a name")
+		}
+		if names[tenant.Name] {
+			return fmt.Errorf("duplicate tenant name: %s", tenant.Name)
+		}
+		names[tenant.Name] = true
+		if tenant.RequestsPerSecond < 0 || tenant.Burst < 0 {
+			return fmt.Errorf("invalid rate limit of tenant %s", tenant.Name)
+		}
+	}
+	for _, key := range keys {
+		if key.Tenant != "" && !names[key.Tenant] {
+			return fmt.Errorf("access key %s names unknown tenant %s", key.Name, key.Tenant)
+		}
+	}
+	return nil
+}
+
+// newTenantRoutes returns the routes of the tenants of config. The
+// upstreams and limiters of previous routes are kept if they are
+// unchanged, the upstreams that are not kept are returned as stale and
+// should be closed once the routes are in use.
+func newTenantRoutes(config Config, previous map[string]*tenantRoute, previousForward ForwardOptions) (routes map[string]*tenantRoute, stale []forwardTarget, err error) {
+	built := make(map[string]*tenantRoute, len(config.Tenants))
+	kept := make(map[forwardTarget]bool)
+	defer func() {
+		if err != nil {
+			for _, route := range built {
+				if route.forwarder != nil && !kept[route.forwarder] {
+					route.forwarder.Close()
+				}
+			}
+		}
+	}()
+	for _, tenant := range config.Tenants {
+		route := &tenantRoute{Tenant: tenant}
+		old := previous[tenant.Name]
+		if len(tenant.ForwardAddresses) > 0 {
+			if old != nil && old.forwarder != nil && config.Forward == previousForward &&
+				strings.Join(old.ForwardAddresses, ",") == strings.Join(tenant.ForwardAddresses, ",") {
+				route.forwarder = old.forwarder
+				kept[old.forwarder] = true
+			} else {
+				pool, err := newForwarder(tenant.ForwardAddresses, config.Forward)
+				if err != nil {
+					return nil, nil, fmt.Errorf("tenant %s: %w", tenant.Name, err)
+				}
+				route.forwarder = pool
+			}
+		}
+		if tenant.RequestsPerSecond > 0 {
+			if old != nil && old.limiter != nil && old.RequestsPerSecond == tenant.RequestsPerSecond && old.Burst == tenant.Burst {
+				route.limiter = old.limiter
+			} else {
+				burst := tenant.Burst
+				if burst == 0 {
+					burst = 1
+				}
+				route.limiter = rate.NewLimiter(rate.Limit(tenant.RequestsPerSecond), burst)
+			}
+		}
+		built[tenant.Name] = route
+	}
+	for _, route := range previous {
+		if route.forwarder != nil && !kept[route.forwarder] {
+			stale = append(stale, route.forwarder)
+		}
+	}
+	return built, stale, nil
+}
+
+// allows reports whether the tenant may deliver events of eventType. A nil
+// route allows every event type.
+func (r *tenantRoute) allows(eventType string) bool {
+	if r == nil || len(r.EventTypes) == 0 {
+		return true
+	}
+	for _, allowed := range r.EventTypes {
+		if allowed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// allow reports whether a request of the tenant is within its rate limit.
+func (r *tenantRoute) allow() bool {
+	return r == nil || r.limiter == nil || r.limiter.Allow()
+}
+
+// tag prefixes the tags of msgs with the tag prefix of the tenant.
+func (r *tenantRoute) tag(msgs []*protocol.MessageExt) {
+	if r == nil || r.TagPrefix == "" {
+		return
+	}
+	for _, msg := range msgs {
+		msg.Tag = r.TagPrefix + "." + msg.Tag
+	}
+}
+
+// count counts a request of the tenant.
+func (r *tenantRoute) count(status string) {
+	if r != nil {
+		tenantRequestsTotal.WithLabelValues(r.Name, status).Inc()
+	}
+}
+
+// targetFor returns the upstreams of the named tenant, or the default
+// upstreams if tenant is empty. Records of a tenant that is not configured
+// are not forwarded to the default upstreams, they may not belong there.
+// The caller must hold a read lock of configMu.
+func targetFor(tenant string) (forwardTarget, error) {
+	if tenant == "" {
+		return forwarder, nil
+	}
+	route, ok := tenants[tenant]
+	if !ok {
+		return nil, fmt.Errorf("tenant %s is not configured", tenant)
+	}
+	if route.forwarder == nil {
+		return forwarder, nil
+	}
+	return route.forwarder, nil
+}
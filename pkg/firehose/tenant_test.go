@@ -0,0 +1,137 @@
+package firehose
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// tagTarget is a forward target recording the tags of the messages sent
+// to it.
+type tagTarget struct {
+	closeTarget
+	tags []string
+}
+
+func (t *tagTarget) Send(msg protocol.ChunkEncoder) error {
+	if m, ok := msg.(*protocol.MessageExt); ok {
+		t.tags = append(t.tags, m.Tag)
+	}
+	return nil
+}
+
+func TestValidateTenants(t *testing.T) {
+	Convey("Tenants should be validated", t, func() {
+		keys := []AccessKey{{Name: "a", Key: "a", Tenant: "team-a"}, {Name: "b", Key: "b"}}
+		So(validateTenants([]Tenant{{Name: "team-a"}}, keys), ShouldBeNil)
+		So(validateTenants(nil, keys), ShouldNotBeNil)
+		So(validateTenants([]Tenant{{Name: "team-a"}, {Name: "team-a"}}, keys), ShouldNotBeNil)
+		So(validateTenants([]Tenant{{Name: "team-a"}, {}}, keys), ShouldNotBeNil)
+		So(validateTenants([]Tenant{{Name: "team-a", RequestsPerSecond: -1}}, keys), ShouldNotBeNil)
+	})
+}
+
+func TestNewTenantRoutes(t *testing.T) {
+	Convey("Given the routes of a tenant", t, func() {
+		config := Config{Tenants: []Tenant{{
+			Name:              "team-a",
+			ForwardAddresses:  []string{"127.0.0.1:1"},
+			RequestsPerSecond: 10,
+		}}}
+		routes, stale, err := newTenantRoutes(config, nil, ForwardOptions{})
+		So(err, ShouldBeNil)
+		So(stale, ShouldBeEmpty)
+		route := routes["team-a"]
+		Reset(func() {
+			route.forwarder.Close()
+		})
+
+		Convey("Unchanged upstreams and limits should be kept on reload", func() {
+			reloaded, stale, err := newTenantRoutes(config, routes, ForwardOptions{})
+			So(err, ShouldBeNil)
+			So(stale, ShouldBeEmpty)
+			So(reloaded["team-a"].forwarder, ShouldEqual, route.forwarder)
+			So(reloaded["team-a"].limiter, ShouldEqual, route.limiter)
+		})
+
+		Convey("Changed upstreams should be replaced", func() {
+			changed := Config{Tenants: []Tenant{{Name: "team-a", ForwardAddresses: []string{"127.0.0.1:2"}}}}
+			reloaded, stale, err := newTenantRoutes(changed, routes, ForwardOptions{})
+			So(err, ShouldBeNil)
+			defer reloaded["team-a"].forwarder.Close()
+			So(reloaded["team-a"].forwarder, ShouldNotEqual, route.forwarder)
+			So(reloaded["team-a"].limiter, ShouldBeNil)
+			So(stale, ShouldResemble, []forwardTarget{route.forwarder})
+		})
+	})
+}
+
+func TestFirehoseHandlerTenants(t *testing.T) {
+	eventTypeHeaderName = "X-EVENT-TYPE"
+	Convey("Given a tenant with its own upstream", t, func() {
+		defaultTarget := &tagTarget{}
+		tenantTarget := &tagTarget{}
+		forwarder = defaultTarget
+		accessKeys = []AccessKey{
+			{Name: "team-a", Key: "team-a-key", Tenant: "team-a"},
+			{Name: "other", Key: testToken},
+		}
+		tenants = map[string]*tenantRoute{"team-a": {
+			Tenant:    Tenant{Name: "team-a", TagPrefix: "team-a", EventTypes: []string{"raw"}},
+			forwarder: tenantTarget,
+		}}
+		Reset(func() {
+			tenants = nil
+		})
+		send := func(key, eventType string) int {
+			body, _ := json.Marshal(firehoseRequestBody{
+				RequestID: "a",
+				Records:   []firehoseRecord{{Data: []byte(base64.StdEncoding.EncodeToString([]byte("hello")))}},
+			})
+			r, _ := http.NewRequest("POST", "", bytes.NewBuffer(body))
+			r.Header.Set(accessKeyHeaderName, key)
+			r.Header.Set(requestIDHeaderName, "a")
+			r.Header.Set(commonAttributesHeaderName, `{"commonAttributes":{"X-EVENT-TYPE":"`+eventType+`"}}`)
+			w := httptest.NewRecorder()
+			firehoseHandler(w, r)
+			return w.Code
+		}
+
+		Convey("Its records should be prefixed and sent to its upstream", func() {
+			So(send("team-a-key", "raw"), ShouldEqual, http.StatusOK)
+			So(tenantTarget.tags, ShouldResemble, []string{"team-a.raw"})
+			So(defaultTarget.tags, ShouldBeEmpty)
+		})
+
+		Convey("Records of other keys should go to the default upstream", func() {
+			So(send(testToken, "raw"), ShouldEqual, http.StatusOK)
+			So(defaultTarget.tags, ShouldResemble, []string{"raw"})
+			So(tenantTarget.tags, ShouldBeEmpty)
+		})
+
+		Convey("Event types not allowed for the tenant should be forbidden", func() {
+			So(send("team-a-key", "cloudwatchlogs"), ShouldEqual, http.StatusForbidden)
+		})
+
+		Convey("Requests beyond the rate limit should be rejected", func() {
+			routes, _, err := newTenantRoutes(Config{Tenants: []Tenant{{Name: "team-a", RequestsPerSecond: 0.001, Burst: 1}}}, nil, ForwardOptions{})
+			So(err, ShouldBeNil)
+			tenants = routes
+			So(send("team-a-key", "raw"), ShouldEqual, http.StatusOK)
+			So(send("team-a-key", "raw"), ShouldEqual, http.StatusTooManyRequests)
+			So(send(testToken, "raw"), ShouldEqual, http.StatusOK)
+		})
+
+		Convey("Buffered records of a removed tenant should not go to the default upstream", func() {
+			msgs := []*protocol.MessageExt{protocol.NewMessageExt("raw", protocol.Record{"data": "hello"})}
+			So(forwardCurrent("removed", "raw", msgs), ShouldNotBeNil)
+			So(defaultTarget.tags, ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,265 @@
+package firehose
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// Strategies for choosing between multiple forward upstreams.
+const (
+	// StrategyRoundRobin spreads messages evenly over the healthy
+	// upstreams.
+	StrategyRoundRobin = "round-robin"
+	// StrategyLeastInflight sends to the healthy upstream with the fewest
+	// messages in flight.
+	StrategyLeastInflight = "least-inflight"
+	// StrategyFailover sends to the first healthy upstream in the
+	// configured order, the others are standbys.
+	StrategyFailover = "failover"
+)
+
+var (
+	upstreamHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_forward_upstream_healthy",
+			Help: "Whether a forward upstream is considered healthy",
+		},
+		[]string{"upstream"},
+	)
+	upstreamInflight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_forward_upstream_inflight",
+			Help: "Number of messages being sent to a forward upstream",
+		},
+		[]string{"upstream"},
+	)
+	upstreamSendsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_forward_upstream_sends_total",
+			Help: "Number of messages sent to a forward upstream by status",
+		},
+		[]string{"upstream", "status"},
+	)
+)
+
+// forwardTarget is where forwarded messages are sent, either a single
+// connection or a pool of upstreams.
+type forwardTarget interface {
+	Send(msg protocol.ChunkEncoder) error
+	Check() error
+	Close()
+}
+
+// validStrategy reports whether strategy is a known upstream strategy.
+func validStrategy(strategy string) bool {
+	switch strategy {
+	case StrategyRoundRobin, StrategyLeastInflight, StrategyFailover:
+		return true
+	}
+	return false
+}
+
+// newForwarder returns a pool of upstreams for the forward addresses.
+func newForwarder(addresses []string, opts ForwardOptions) (*upstreamPool, error) {
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no forward address configured")
+	}
+	var conns []*forwardConnection
+	for _, address := range addresses {
+		factory, err := newConnectionFactory(address, opts.TLS)
+		if err != nil {
+			return nil, err
+		}
+		client := &fluentclient.Client{
+			ConnectionFactory: factory,
+			RequireAck:        opts.RequireAck,
+			Timeout:           opts.AckTimeout,
+			Hostname:          opts.Hostname,
+		}
+		if opts.SharedKey != "" {
+			client.AuthInfo = fluentclient.AuthInfo{
+				SharedKey: []byte(opts.SharedKey),
+				Username:  opts.Username,
+				Password:  opts.Password,
+			}
+		}
+		conns = append(conns, newForwardConnection(address, client, opts.ReconnectBackoff, opts.ReconnectMaxBackoff))
+	}
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = StrategyRoundRobin
+	}
+	return newUpstreamPool(conns, strategy, opts.HealthCheckInterval), nil
+}
+
+// upstream is a member of an upstream pool.
+type upstream struct {
+	// inflight is accessed atomically and first in the struct to keep it
+	// 64-bit aligned
+	inflight int64
+	healthy  int32
+	conn     *forwardConnection
+}
+
+func (u *upstream) isHealthy() bool {
+	return atomic.LoadInt32(&u.healthy) == 1
+}
+
+// setHealthy records the health of the upstream and logs changes.
+func (u *upstream) setHealthy(healthy bool) {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	if atomic.SwapInt32(&u.healthy, v) != v {
+		if healthy {
+			log.Infof("forward upstream %s is healthy", u.conn.upstream)
+		} else {
+			log.Warnf("forward upstream %s is unhealthy", u.conn.upstream)
+		}
+	}
+	upstreamHealthy.WithLabelValues(u.conn.upstream).Set(float64(v))
+}
+
+func (u *upstream) send(msg protocol.ChunkEncoder) error {
+	atomic.AddInt64(&u.inflight, 1)
+	upstreamInflight.WithLabelValues(u.conn.upstream).Inc()
+	defer func() {
+		atomic.AddInt64(&u.inflight, -1)
+		upstreamInflight.WithLabelValues(u.conn.upstream).Dec()
+	}()
+	if err := u.conn.Send(msg); err != nil {
+		upstreamSendsTotal.WithLabelValues(u.conn.upstream, "error").Inc()
+		u.setHealthy(false)
+		return err
+	}
+	upstreamSendsTotal.WithLabelValues(u.conn.upstream, "success").Inc()
+	u.setHealthy(true)
+	return nil
+}
+
+// check dials the upstream if it is not connected and updates its health.
+func (u *upstream) check() error {
+	err := u.conn.Check()
+	u.setHealthy(err == nil)
+	return err
+}
+
+// upstreamPool sends messages to one of several upstreams. A message that
+// fails on one upstream is retried on the next, so a single sick upstream
+// does not fail the request. Upstreams are marked unhealthy when a send
+// fails and healthy again when a send or the periodic health check
+// succeeds. Unhealthy upstreams are only tried when no healthy one is left.
+type upstreamPool struct {
+	upstreams []*upstream
+	strategy  string
+	next      uint32
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// newUpstreamPool returns a pool of conns using strategy. All upstreams
+// start out healthy. If interval is positive, upstreams are dialed every
+// interval to update their health.
+func newUpstreamPool(conns []*forwardConnection, strategy string, interval time.Duration) *upstreamPool {
+	p := &upstreamPool{
+		strategy: strategy,
+		stop:     make(chan struct{}),
+	}
+	for _, conn := range conns {
+		u := &upstream{conn: conn}
+		u.setHealthy(true)
+		p.upstreams = append(p.upstreams, u)
+	}
+	if interval > 0 {
+		p.wg.Add(1)
+		go p.run(interval)
+	}
+	return p
+}
+
+// Send sends msg to the first upstream that accepts it, in the order of
+// the strategy.
+func (p *upstreamPool) Send(msg protocol.ChunkEncoder) error {
+	var errs []string
+	for _, u := range p.candidates() {
+		err := u.send(msg)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", u.conn.upstream, err))
+	}
+	return fmt.Errorf("all forward upstreams failed: %s", strings.Join(errs, "; "))
+}
+
+// Check dials all upstreams and reports whether at least one of them is
+// reachable. It is used as a readiness check.
+func (p *upstreamPool) Check() error {
+	var errs []string
+	for _, u := range p.upstreams {
+		if err := u.check(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", u.conn.upstream, err))
+		}
+	}
+	if len(errs) == len(p.upstreams) {
+		return fmt.Errorf("no forward upstream is available: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Close stops the health checks and closes all connections.
+func (p *upstreamPool) Close() {
+	close(p.stop)
+	p.wg.Wait()
+	for _, u := range p.upstreams {
+		u.conn.Close()
+	}
+}
+
+func (p *upstreamPool) run(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			for _, u := range p.upstreams {
+				u.check()
+			}
+		}
+	}
+}
+
+// candidates returns the upstreams in the order they are tried: the
+// healthy ones in the order of the strategy, followed by the unhealthy
+// ones as a last resort.
+func (p *upstreamPool) candidates() []*upstream {
+	ordered := make([]*upstream, len(p.upstreams))
+	copy(ordered, p.upstreams)
+	if p.strategy == StrategyRoundRobin {
+		n := int(atomic.AddUint32(&p.next, 1)-1) % len(ordered)
+		ordered = append(ordered[n:], ordered[:n]...)
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		hi, hj := ordered[i].isHealthy(), ordered[j].isHealthy()
+		if hi != hj {
+			return hi
+		}
+		if p.strategy == StrategyLeastInflight && hi {
+			return atomic.LoadInt64(&ordered[i].inflight) < atomic.LoadInt64(&ordered[j].inflight)
+		}
+		return false
+	})
+	return ordered
+}
@@ -0,0 +1,137 @@
+package firehose
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	fluentclient "github.com/IBM/fluent-forward-go/fluent/client"
+	"github.com/IBM/fluent-forward-go/fluent/client/clientfakes"
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func refuseConnection() (net.Conn, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestUpstreamPool(t *testing.T) {
+	Convey("Given three upstreams", t, func() {
+		var factories []*clientfakes.FakeConnectionFactory
+		var conns []*forwardConnection
+		for _, name := range []string{"a", "b", "c"} {
+			factory := &clientfakes.FakeConnectionFactory{}
+			factory.NewStub = discardConnection
+			factories = append(factories, factory)
+			conns = append(conns, newForwardConnection(name, &fluentclient.Client{ConnectionFactory: factory}, time.Minute, time.Minute))
+		}
+		msg := protocol.NewMessageExt("test", protocol.Record{"message": "hello"})
+		sends := func(upstream string) float64 {
+			return testutil.ToFloat64(upstreamSendsTotal.WithLabelValues(upstream, "success"))
+		}
+
+		Convey("When balancing round-robin", func() {
+			pool := newUpstreamPool(conns, StrategyRoundRobin, 0)
+			Reset(pool.Close)
+			before := []float64{sends("a"), sends("b"), sends("c")}
+			for i := 0; i < 6; i++ {
+				So(pool.Send(msg), ShouldBeNil)
+			}
+			Convey("Then every upstream should get the same share", func() {
+				So(sends("a")-before[0], ShouldEqual, 2)
+				So(sends("b")-before[1], ShouldEqual, 2)
+				So(sends("c")-before[2], ShouldEqual, 2)
+			})
+		})
+
+		Convey("When failing over", func() {
+			pool := newUpstreamPool(conns, StrategyFailover, 0)
+			Reset(pool.Close)
+			So(pool.Send(msg), ShouldBeNil)
+			So(factories[0].NewCallCount(), ShouldEqual, 1)
+			So(factories[1].NewCallCount(), ShouldEqual, 0)
+
+			Convey("Then a failing primary should be replaced by the standby", func() {
+				factories[0].NewStub = refuseConnection
+				conns[0].Close()
+				So(pool.Send(msg), ShouldBeNil)
+				So(pool.upstreams[0].isHealthy(), ShouldBeFalse)
+				So(testutil.ToFloat64(upstreamHealthy.WithLabelValues("a")), ShouldEqual, 0)
+				So(factories[1].NewCallCount(), ShouldEqual, 1)
+
+				Convey("And the primary should not be retried while unhealthy", func() {
+					So(pool.Send(msg), ShouldBeNil)
+					So(factories[0].NewCallCount(), ShouldEqual, 2)
+				})
+
+				Convey("And the primary should be used again once a check succeeds", func() {
+					factories[0].NewStub = discardConnection
+					conns[0].nextAttempt = time.Now()
+					So(pool.Check(), ShouldBeNil)
+					So(pool.upstreams[0].isHealthy(), ShouldBeTrue)
+					So(pool.candidates()[0], ShouldEqual, pool.upstreams[0])
+				})
+			})
+		})
+
+		Convey("When balancing by messages in flight", func() {
+			pool := newUpstreamPool(conns, StrategyLeastInflight, 0)
+			Reset(pool.Close)
+			pool.upstreams[0].inflight = 2
+			pool.upstreams[1].inflight = 1
+			So(pool.candidates()[0], ShouldEqual, pool.upstreams[2])
+			So(pool.candidates()[1], ShouldEqual, pool.upstreams[1])
+		})
+
+		Convey("When all upstreams are down", func() {
+			for _, factory := range factories {
+				factory.NewStub = refuseConnection
+			}
+			pool := newUpstreamPool(conns, StrategyRoundRobin, 0)
+			Reset(pool.Close)
+			Convey("Then sending and the readiness check should fail", func() {
+				So(pool.Send(msg), ShouldNotBeNil)
+				So(pool.Check(), ShouldNotBeNil)
+			})
+		})
+
+		Convey("When only one upstream is down", func() {
+			factories[1].NewStub = refuseConnection
+			pool := newUpstreamPool(conns, StrategyRoundRobin, 0)
+			Reset(pool.Close)
+			Convey("Then the readiness check should pass", func() {
+				So(pool.Check(), ShouldBeNil)
+				So(pool.upstreams[1].isHealthy(), ShouldBeFalse)
+			})
+		})
+
+		Convey("When health checks are periodic", func() {
+			factories[2].NewStub = refuseConnection
+			pool := newUpstreamPool(conns, StrategyRoundRobin, 10*time.Millisecond)
+			Reset(pool.Close)
+			Convey("Then an unreachable upstream should be marked unhealthy", func() {
+				deadline := time.Now().Add(time.Second)
+				for pool.upstreams[2].isHealthy() && time.Now().Before(deadline) {
+					time.Sleep(5 * time.Millisecond)
+				}
+				So(pool.upstreams[2].isHealthy(), ShouldBeFalse)
+				So(pool.upstreams[0].isHealthy(), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestNewForwarder(t *testing.T) {
+	Convey("Invalid forward addresses should be rejected", t, func() {
+		_, err := newForwarder(nil, ForwardOptions{})
+		So(err, ShouldNotBeNil)
+		_, err = newForwarder([]string{"127.0.0.1:24224", "udp://127.0.0.1:24224"}, ForwardOptions{})
+		So(err, ShouldNotBeNil)
+	})
+	Convey("Unknown strategies should be rejected", t, func() {
+		So(ForwardOptions{Strategy: "random"}.validate(), ShouldNotBeNil)
+		So(ForwardOptions{Strategy: StrategyFailover}.validate(), ShouldBeNil)
+	})
+}
@@ -0,0 +1,685 @@
+package firehose
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/tinylib/msgp/msgp"
+)
+
+// Overflow policies of the write-ahead log.
+const (
+	// OverflowReject fails Firehose requests while the log is full, so
+	// Firehose retries them or backs them up to S3.
+	OverflowReject = "reject"
+	// OverflowDropOldest deletes the oldest segment of the log to make
+	// room for new records.
+	OverflowDropOldest = "drop-oldest"
+)
+
+const (
+	walSegmentSuffix       = ".wal"
+	walCheckpointFile      = "checkpoint"
+	walTenantsDir          = "tenants"
+	walFrameHeaderSize     = 8
+	defaultWALSegmentBytes = 64 * 1024 * 1024
+	walRetryInterval       = time.Second
+)
+
+var errWALFull = errors.New("write-ahead log is full")
+
+var (
+	walBytes = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "fluenthose_wal_bytes",
+			Help: "Size of the write-ahead log on disk",
+		},
+	)
+	walAppendsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "fluenthose_wal_appends_total",
+			Help: "Number of requests appended to the write-ahead log by status",
+		},
+		[]string{"status"},
+	)
+	walDroppedSegmentsTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "fluenthose_wal_dropped_segments_total",
+			Help: "Number of write-ahead log segments dropped before they were forwarded",
+		},
+	)
+)
+
+// WALOptions configures the write-ahead log between the HTTP endpoint and
+// the forwarder.
+type WALOptions struct {
+	// Dir is the directory of the log. The log is disabled if it is empty
+	// and records are forwarded before Firehose is answered.
+	Dir string
+	// MaxBytes caps the size of the log of each tenant on disk. It is
+	// unlimited if zero.
+	MaxBytes int64
+	// SegmentBytes is the size at which a new segment file is started.
+	SegmentBytes int64
+	// Overflow is what happens when the log is full, OverflowReject or
+	// OverflowDropOldest.
+	Overflow string
+}
+
+// validate checks the options for invalid values.
+func (o WALOptions) validate() error {
+	if o.Dir == "" {
+		return nil
+	}
+	if o.MaxBytes < 0 || o.SegmentBytes < 0 {
+		return fmt.Errorf("write-ahead log sizes must not be negative")
+	}
+	switch o.Overflow {
+	case "", OverflowReject, OverflowDropOldest:
+		return nil
+	}
+	return fmt.Errorf("unknown write-ahead log overflow policy: %s", o.Overflow)
+}
+
+// walPosition is the position of an entry in the log.
+type walPosition struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// writeAheadLog persists decoded messages on disk until they are
+// forwarded. Entries are appended to segment files as frames of a length,
+// a CRC-32 checksum and the msgpack encoded messages. A drainer forwards
+// the entries in order and records its position in a checkpoint file.
+// Delivery is at least once: entries forwarded before a crash but after
+// the last checkpoint are forwarded again.
+type writeAheadLog struct {
+	mu       sync.Mutex
+	opts     WALOptions
+	segments []uint64
+	size     int64
+	file     *os.File
+	fileSize int64
+	read     walPosition
+	reader   *os.File
+	started  bool
+	notify   chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// openWAL opens the log in opts.Dir, creating it if needed. A torn frame at
+// the end of the last segment, left by a crash during an append, is cut
+// off.
+func openWAL(opts WALOptions) (*writeAheadLog, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = defaultWALSegmentBytes
+	}
+	if opts.Overflow == "" {
+		opts.Overflow = OverflowReject
+	}
+	if err := os.MkdirAll(opts.Dir, 0750); err != nil {
+		return nil, err
+	}
+	l := &writeAheadLog{
+		opts:   opts,
+		notify: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	files, err := ioutil.ReadDir(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), walSegmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(fi.Name(), walSegmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.segments = append(l.segments, seq)
+		l.size += fi.Size()
+	}
+	sort.Slice(l.segments, func(i, j int) bool { return l.segments[i] < l.segments[j] })
+	if len(l.segments) == 0 {
+		if err := l.createSegment(1); err != nil {
+			return nil, err
+		}
+	} else if err := l.openLastSegment(); err != nil {
+		return nil, err
+	}
+
+	l.read = walPosition{Segment: l.segments[0]}
+	if data, err := ioutil.ReadFile(filepath.Join(opts.Dir, walCheckpointFile)); err == nil {
+		var pos walPosition
+		if err := json.Unmarshal(data, &pos); err != nil {
+			log.Errorf("ignoring invalid write-ahead log checkpoint: %s", err)
+		} else if pos.Segment >= l.segments[0] && pos.Segment <= l.segments[len(l.segments)-1] {
+			l.read = pos
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if l.read.Segment == l.segments[len(l.segments)-1] && l.read.Offset > l.fileSize {
+		l.read.Offset = l.fileSize
+	}
+	walBytes.Add(float64(l.size))
+	return l, nil
+}
+
+func (l *writeAheadLog) segmentPath(seq uint64) string {
+	return filepath.Join(l.opts.Dir, fmt.Sprintf("%020d%s", seq, walSegmentSuffix))
+}
+
+func (l *writeAheadLog) createSegment(seq uint64) error {
+	f, err := os.OpenFile(l.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = f
+	l.fileSize = 0
+	l.segments = append(l.segments, seq)
+	return nil
+}
+
+// openLastSegment opens the last segment for appending and truncates it
+// after its last complete frame.
+func (l *writeAheadLog) openLastSegment() error {
+	path := l.segmentPath(l.segments[len(l.segments)-1])
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	var end int64
+	for {
+		_, n, err := readWALFrame(f, end)
+		if err != nil {
+			break
+		}
+		end += n
+	}
+	if end < fi.Size() {
+		log.Warnf("truncating torn write-ahead log frame in %s at offset %d", path, end)
+		if err := f.Truncate(end); err != nil {
+			f.Close()
+			return err
+		}
+		l.size -= fi.Size() - end
+	}
+	l.file = f
+	l.fileSize = end
+	return nil
+}
+
+// Append writes the msgs of tenant, empty if none, to the log and returns
+// once they are synced to disk.
+func (l *writeAheadLog) Append(tenant, eventType string, msgs []*protocol.MessageExt) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	payload, err := encodeWALEntry(tenant, eventType, msgs)
+	if err != nil {
+		walAppendsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	frame := make([]byte, walFrameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(frame[4:8], crc32.ChecksumIEEE(payload))
+	copy(frame[walFrameHeaderSize:], payload)
+	n := int64(len(frame))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opts.MaxBytes > 0 {
+		for l.size+n > l.opts.MaxBytes {
+			if l.opts.Overflow != OverflowDropOldest || !l.dropOldest() {
+				walAppendsTotal.WithLabelValues("rejected").Inc()
+				return errWALFull
+			}
+		}
+	}
+	if l.fileSize > 0 && l.fileSize+n > l.opts.SegmentBytes {
+		if err := l.createSegment(l.segments[len(l.segments)-1] + 1); err != nil {
+			walAppendsTotal.WithLabelValues("error").Inc()
+			return err
+		}
+	}
+	if _, err := l.file.Write(frame); err != nil {
+		l.file.Truncate(l.fileSize)
+		walAppendsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		l.file.Truncate(l.fileSize)
+		walAppendsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	l.fileSize += n
+	l.size += n
+	walBytes.Add(float64(n))
+	walAppendsTotal.WithLabelValues("success").Inc()
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Check reports whether the log accepts new entries. It is used as a
+// readiness check instead of the forwarder, as records are buffered while
+// the forwarder is down.
+func (l *writeAheadLog) Check() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opts.MaxBytes > 0 && l.opts.Overflow == OverflowReject && l.size >= l.opts.MaxBytes {
+		return errWALFull
+	}
+	return nil
+}
+
+// dropOldest deletes the oldest segment and reports whether it did. If
+// only the segment being appended to is left, a new one is started first,
+// unless it is empty.
+func (l *writeAheadLog) dropOldest() bool {
+	if len(l.segments) <= 1 {
+		if l.fileSize == 0 {
+			return false
+		}
+		if err := l.createSegment(l.segments[0] + 1); err != nil {
+			log.Errorf("failed to create write-ahead log segment: %s", err)
+			return false
+		}
+	}
+	seq := l.segments[0]
+	log.Warnf("write-ahead log is full, dropping segment %d", seq)
+	walDroppedSegmentsTotal.Inc()
+	if err := l.removeSegment(); err != nil {
+		log.Errorf("failed to drop write-ahead log segment %d: %s", seq, err)
+		return false
+	}
+	if l.read.Segment <= seq {
+		l.closeReader()
+		l.read = walPosition{Segment: l.segments[0]}
+		l.writeCheckpoint()
+	}
+	return true
+}
+
+// removeSegment deletes the oldest segment.
+func (l *writeAheadLog) removeSegment() error {
+	path := l.segmentPath(l.segments[0])
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	l.segments = l.segments[1:]
+	l.size -= fi.Size()
+	walBytes.Sub(float64(fi.Size()))
+	return nil
+}
+
+// Start forwards the entries of the log with send in the background until
+// Close is called. A failed entry is retried until it is sent.
+func (l *writeAheadLog) Start(send func(tenant, eventType string, msgs []*protocol.MessageExt) error) {
+	l.started = true
+	go l.drain(send)
+}
+
+func (l *writeAheadLog) drain(send func(tenant, eventType string, msgs []*protocol.MessageExt) error) {
+	defer close(l.done)
+	for {
+		pos, next, payload, err := l.next()
+		if err == io.EOF {
+			select {
+			case <-l.stop:
+				return
+			case <-l.notify:
+			}
+			continue
+		}
+		if err != nil {
+			log.Errorf("failed to read write-ahead log: %s", err)
+			if !l.wait(walRetryInterval) {
+				return
+			}
+			continue
+		}
+		tenant, eventType, msgs, err := decodeWALEntry(payload)
+		if err != nil {
+			log.Errorf("skipping undecodable write-ahead log entry at %d/%d: %s", pos.Segment, pos.Offset, err)
+			l.commit(pos, next)
+			continue
+		}
+		if err := send(tenant, eventType, msgs); err != nil {
+			log.Errorf("failed to forward buffered %s event, retrying: %s", eventType, err)
+			if !l.wait(walRetryInterval) {
+				return
+			}
+			continue
+		}
+		l.commit(pos, next)
+	}
+}
+
+// wait waits for d and reports whether the log is still open.
+func (l *writeAheadLog) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-l.stop:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// next returns the entry at the read position and the position after it.
+// Fully read segments other than the current one are deleted. It returns
+// io.EOF if all entries have been read.
+func (l *writeAheadLog) next() (walPosition, walPosition, []byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for {
+		current := l.segments[len(l.segments)-1]
+		if l.read.Segment == current && l.read.Offset >= l.fileSize {
+			return l.read, l.read, nil, io.EOF
+		}
+		if l.reader == nil {
+			f, err := os.Open(l.segmentPath(l.read.Segment))
+			if err != nil {
+				return l.read, l.read, nil, err
+			}
+			l.reader = f
+		}
+		payload, n, err := readWALFrame(l.reader, l.read.Offset)
+		if err == nil {
+			return l.read, walPosition{Segment: l.read.Segment, Offset: l.read.Offset + n}, payload, nil
+		}
+		if l.read.Segment == current {
+			return l.read, l.read, nil, err
+		}
+		if err != io.EOF {
+			log.Errorf("skipping the rest of write-ahead log segment %d: %s", l.read.Segment, err)
+		}
+		// the segment is done, move on to the next one
+		l.closeReader()
+		for len(l.segments) > 1 && l.segments[0] <= l.read.Segment {
+			if err := l.removeSegment(); err != nil {
+				log.Errorf("failed to remove write-ahead log segment: %s", err)
+				break
+			}
+		}
+		for _, seq := range l.segments {
+			if seq > l.read.Segment {
+				l.read = walPosition{Segment: seq}
+				break
+			}
+		}
+		l.writeCheckpoint()
+	}
+}
+
+// commit moves the read position from pos to next, unless the entry was
+// dropped in the meantime.
+func (l *writeAheadLog) commit(pos, next walPosition) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.read != pos {
+		return
+	}
+	l.read = next
+	l.writeCheckpoint()
+}
+
+// writeCheckpoint persists the read position. It is not synced: losing it
+// in a crash only means forwarding some entries again.
+func (l *writeAheadLog) writeCheckpoint() {
+	data, _ := json.Marshal(l.read)
+	path := filepath.Join(l.opts.Dir, walCheckpointFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0640); err != nil {
+		log.Errorf("failed to write write-ahead log checkpoint: %s", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Errorf("failed to write write-ahead log checkpoint: %s", err)
+	}
+}
+
+func (l *writeAheadLog) closeReader() {
+	if l.reader != nil {
+		l.reader.Close()
+		l.reader = nil
+	}
+}
+
+// Close stops the drainer and closes the log.
+func (l *writeAheadLog) Close() {
+	close(l.stop)
+	if l.started {
+		<-l.done
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closeReader()
+	l.file.Close()
+	walBytes.Sub(float64(l.size))
+}
+
+// walSet keeps a write-ahead log per tenant, so the entries of a tenant
+// whose upstreams are down do not hold up the entries of other tenants.
+// The log of records without a tenant is in the directory of the options,
+// the logs of tenants in subdirectories of walTenantsDir named after them.
+type walSet struct {
+	mu   sync.Mutex
+	opts WALOptions
+	logs map[string]*writeAheadLog
+	send func(tenant, eventType string, msgs []*protocol.MessageExt) error
+}
+
+// openWALSet opens the log of records without a tenant and the logs of
+// the tenants found in opts.Dir.
+func openWALSet(opts WALOptions) (*walSet, error) {
+	s := &walSet{opts: opts, logs: make(map[string]*writeAheadLog)}
+	l, err := openWAL(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.logs[""] = l
+	dirs, err := ioutil.ReadDir(filepath.Join(opts.Dir, walTenantsDir))
+	if err != nil && !os.IsNotExist(err) {
+		s.Close()
+		return nil, err
+	}
+	for _, fi := range dirs {
+		tenant, err := url.PathUnescape(fi.Name())
+		if !fi.IsDir() || err != nil || tenant == "" {
+			continue
+		}
+		if _, err := s.log(tenant); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// walTenantDir returns the directory of the log of tenant below dir. The
+// name is escaped, so every tenant name is a single directory.
+func walTenantDir(dir, tenant string) string {
+	name := url.PathEscape(tenant)
+	if name == "." || name == ".." {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return filepath.Join(dir, walTenantsDir, name)
+}
+
+// log returns the log of tenant, opening and starting it if needed. The
+// caller must hold s.mu unless the set is not in use yet.
+func (s *walSet) log(tenant string) (*writeAheadLog, error) {
+	if l, ok := s.logs[tenant]; ok {
+		return l, nil
+	}
+	opts := s.opts
+	opts.Dir = walTenantDir(s.opts.Dir, tenant)
+	l, err := openWAL(opts)
+	if err != nil {
+		return nil, err
+	}
+	s.logs[tenant] = l
+	if s.send != nil {
+		l.Start(s.send)
+	}
+	return l, nil
+}
+
+// Append writes msgs to the log of tenant like writeAheadLog.Append.
+func (s *walSet) Append(tenant, eventType string, msgs []*protocol.MessageExt) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	l, err := s.log(tenant)
+	s.mu.Unlock()
+	if err != nil {
+		walAppendsTotal.WithLabelValues("error").Inc()
+		return err
+	}
+	return l.Append(tenant, eventType, msgs)
+}
+
+// Start forwards the entries of every log with send, each log with its
+// own drainer, until Close is called.
+func (s *walSet) Start(send func(tenant, eventType string, msgs []*protocol.MessageExt) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.send = send
+	for _, l := range s.logs {
+		l.Start(send)
+	}
+}
+
+// Check reports whether a log accepts new entries. Only a full log of
+// every tenant makes the endpoint unready, as one tenant falling behind
+// should not take the endpoint out of service for the others.
+func (s *walSet) Check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.logs {
+		if err := l.Check(); err == nil {
+			return nil
+		}
+	}
+	return errWALFull
+}
+
+// Close stops the drainers and closes the logs.
+func (s *walSet) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, l := range s.logs {
+		l.Close()
+	}
+}
+
+// readWALFrame reads the frame at offset of f and returns its payload and
+// its size including the header.
+func readWALFrame(f io.ReaderAt, offset int64) ([]byte, int64, error) {
+	var header [walFrameHeaderSize]byte
+	if _, err := f.ReadAt(header[:], offset); err != nil {
+		return nil, 0, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(header[0:4]))
+	if _, err := f.ReadAt(payload, offset+walFrameHeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	return payload, int64(len(payload)) + walFrameHeaderSize, nil
+}
+
+// encodeWALEntry encodes the event type, the tenant, empty if none, and the
+// messages of a request.
+func encodeWALEntry(tenant, eventType string, msgs []*protocol.MessageExt) ([]byte, error) {
+	var buf bytes.Buffer
+	w := msgp.NewWriter(&buf)
+	if err := w.WriteString(eventType); err != nil {
+		return nil, err
+	}
+	if err := w.WriteString(tenant); err != nil {
+		return nil, err
+	}
+	if err := w.WriteArrayHeader(uint32(len(msgs))); err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		if err := msg.EncodeMsg(w); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeWALEntry decodes an entry of encodeWALEntry.
+func decodeWALEntry(payload []byte) (string, string, []*protocol.MessageExt, error) {
+	r := msgp.NewReader(bytes.NewReader(payload))
+	eventType, err := r.ReadString()
+	if err != nil {
+		return "", "", nil, err
+	}
+	tenant, err := r.ReadString()
+	if err != nil {
+		return "", "", nil, err
+	}
+	n, err := r.ReadArrayHeader()
+	if err != nil {
+		return "", "", nil, err
+	}
+	msgs := make([]*protocol.MessageExt, n)
+	for i := range msgs {
+		msgs[i] = &protocol.MessageExt{}
+		if err := msgs[i].DecodeMsg(r); err != nil {
+			return "", "", nil, err
+		}
+		if msgs[i].Options == nil {
+			msgs[i].Options = &protocol.MessageOptions{}
+		}
+	}
+	return tenant, eventType, msgs, nil
+}
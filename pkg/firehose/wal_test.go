@@ -0,0 +1,318 @@
+package firehose
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/fluent-forward-go/fluent/protocol"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// walEntry is an entry forwarded by the drainer of a write-ahead log.
+type walEntry struct {
+	tenant    string
+	eventType string
+	msgs      []*protocol.MessageExt
+}
+
+// collect starts draining l into a channel. Sends fail while fail returns
+// true.
+func collect(l *writeAheadLog, fail func() bool) chan walEntry {
+	entries := make(chan walEntry, 100)
+	l.Start(func(tenant, eventType string, msgs []*protocol.MessageExt) error {
+		if fail != nil && fail() {
+			return errors.New("forwarder unavailable")
+		}
+		entries <- walEntry{tenant, eventType, msgs}
+		return nil
+	})
+	return entries
+}
+
+// receive returns the next drained entry or fails after a timeout.
+func receive(entries chan walEntry) walEntry {
+	select {
+	case entry := <-entries:
+		return entry
+	case <-time.After(5 * time.Second):
+		So("no entry drained", ShouldBeEmpty)
+		return walEntry{}
+	}
+}
+
+func testMessages(tag string, n int) []*protocol.MessageExt {
+	var msgs []*protocol.MessageExt
+	for i := 0; i < n; i++ {
+		msgs = append(msgs, &protocol.MessageExt{
+			Tag:       tag,
+			Timestamp: protocol.EventTime{Time: time.Unix(1600110569, int64(i)*int64(time.Millisecond)).UTC()},
+			Record: protocol.Record{
+				"n":         i,
+				"csHeaders": map[string]string{"host": "example.com"},
+			},
+			Options: &protocol.MessageOptions{},
+		})
+	}
+	return msgs
+}
+
+func segmentFiles(dir string) []string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+walSegmentSuffix))
+	return files
+}
+
+func TestWALEntry(t *testing.T) {
+	Convey("Entries should always carry the tenant", t, func() {
+		for _, tenant := range []string{"", "team-a", "raw"} {
+			payload, err := encodeWALEntry(tenant, "raw", testMessages("raw", 1))
+			So(err, ShouldBeNil)
+			decodedTenant, eventType, msgs, err := decodeWALEntry(payload)
+			So(err, ShouldBeNil)
+			So(decodedTenant, ShouldEqual, tenant)
+			So(eventType, ShouldEqual, "raw")
+			So(msgs, ShouldHaveLength, 1)
+		}
+	})
+}
+
+func TestWriteAheadLog(t *testing.T) {
+	Convey("Given a write-ahead log", t, func() {
+		dir := t.TempDir()
+		l, err := openWAL(WALOptions{Dir: dir})
+		So(err, ShouldBeNil)
+		closed := false
+		Reset(func() {
+			if !closed {
+				l.Close()
+			}
+		})
+
+		Convey("Appended messages should be drained in order", func() {
+			So(l.Append("", "cloudfront", testMessages("cloudfront", 2)), ShouldBeNil)
+			So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			entries := collect(l, nil)
+			first := receive(entries)
+			So(first.eventType, ShouldEqual, "cloudfront")
+			So(first.tenant, ShouldBeEmpty)
+			So(first.msgs, ShouldHaveLength, 2)
+			So(first.msgs[1].Timestamp.Time.Equal(time.Unix(1600110569, int64(time.Millisecond))), ShouldBeTrue)
+			record := first.msgs[1].Record.(map[string]interface{})
+			So(record["n"], ShouldEqual, int64(1))
+			So(record["csHeaders"].(map[string]interface{})["host"], ShouldEqual, "example.com")
+			So(receive(entries).eventType, ShouldEqual, "raw")
+
+			Convey("And entries appended later should be drained too", func() {
+				So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+				So(receive(entries).eventType, ShouldEqual, "raw")
+			})
+		})
+
+		Convey("The tenant of an entry should be drained with it", func() {
+			So(l.Append("team-a", "raw", testMessages("raw", 1)), ShouldBeNil)
+			entry := receive(collect(l, nil))
+			So(entry.tenant, ShouldEqual, "team-a")
+			So(entry.eventType, ShouldEqual, "raw")
+			So(entry.msgs, ShouldHaveLength, 1)
+		})
+
+		Convey("Failed sends should be retried", func() {
+			attempts := 0
+			So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			entries := collect(l, func() bool {
+				attempts++
+				return attempts == 1
+			})
+			So(receive(entries).eventType, ShouldEqual, "raw")
+			So(attempts, ShouldEqual, 2)
+		})
+
+		Convey("When the log is reopened", func() {
+			So(l.Append("", "first", testMessages("raw", 1)), ShouldBeNil)
+			entries := collect(l, nil)
+			So(receive(entries).eventType, ShouldEqual, "first")
+			So(l.Append("", "second", testMessages("raw", 1)), ShouldBeNil)
+			So(receive(entries).eventType, ShouldEqual, "second")
+			l.Close()
+			closed = true
+
+			Convey("Then forwarded entries should not be sent again", func() {
+				l, err := openWAL(WALOptions{Dir: dir})
+				So(err, ShouldBeNil)
+				defer l.Close()
+				So(l.Append("", "third", testMessages("raw", 1)), ShouldBeNil)
+				So(receive(collect(l, nil)).eventType, ShouldEqual, "third")
+			})
+		})
+
+		Convey("When the last frame is torn", func() {
+			So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			l.Close()
+			closed = true
+			path := segmentFiles(dir)[0]
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0640)
+			So(err, ShouldBeNil)
+			f.Write([]byte{0, 0, 1, 0, 1, 2, 3})
+			f.Close()
+
+			Convey("Then it should be cut off on open", func() {
+				l, err := openWAL(WALOptions{Dir: dir})
+				So(err, ShouldBeNil)
+				defer l.Close()
+				So(l.Append("", "next", testMessages("raw", 1)), ShouldBeNil)
+				entries := collect(l, nil)
+				So(receive(entries).eventType, ShouldEqual, "raw")
+				So(receive(entries).eventType, ShouldEqual, "next")
+			})
+		})
+	})
+
+	Convey("Given a write-ahead log with small segments", t, func() {
+		dir := t.TempDir()
+		entry, err := encodeWALEntry("", "raw", testMessages("raw", 1))
+		So(err, ShouldBeNil)
+		frame := int64(len(entry) + walFrameHeaderSize)
+		opts := WALOptions{Dir: dir, SegmentBytes: 2 * frame}
+
+		Convey("Drained segments should be deleted", func() {
+			l, err := openWAL(opts)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			for i := 0; i < 5; i++ {
+				So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			}
+			So(segmentFiles(dir), ShouldHaveLength, 3)
+			entries := collect(l, nil)
+			for i := 0; i < 5; i++ {
+				receive(entries)
+			}
+			So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			receive(entries)
+			So(segmentFiles(dir), ShouldHaveLength, 1)
+		})
+
+		Convey("When the log is full and overflow is rejected", func() {
+			opts.MaxBytes = 3 * frame
+			l, err := openWAL(opts)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			for i := 0; i < 3; i++ {
+				So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			}
+			So(l.Append("", "raw", testMessages("raw", 1)), ShouldEqual, errWALFull)
+			So(l.Check(), ShouldEqual, errWALFull)
+		})
+
+		Convey("When the log is full and the oldest entries are dropped", func() {
+			opts.MaxBytes = 3 * frame
+			opts.Overflow = OverflowDropOldest
+			l, err := openWAL(opts)
+			So(err, ShouldBeNil)
+			defer l.Close()
+			dropped := testutil.ToFloat64(walDroppedSegmentsTotal)
+			for _, eventType := range []string{"a", "b", "c", "d", "e"} {
+				So(l.Append("", eventType, testMessages("raw", 1)), ShouldBeNil)
+			}
+			So(l.Check(), ShouldBeNil)
+			So(testutil.ToFloat64(walDroppedSegmentsTotal)-dropped, ShouldEqual, 1)
+			entries := collect(l, nil)
+			for _, eventType := range []string{"c", "d", "e"} {
+				So(receive(entries).eventType, ShouldEqual, eventType)
+			}
+		})
+	})
+
+	Convey("Unknown overflow policies should be rejected", t, func() {
+		So(WALOptions{Dir: "wal", Overflow: "block"}.validate(), ShouldNotBeNil)
+		So(WALOptions{Dir: "wal", Overflow: OverflowDropOldest}.validate(), ShouldBeNil)
+	})
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	Convey("Given a checkpoint beyond a truncated segment", t, func() {
+		dir := t.TempDir()
+		l, err := openWAL(WALOptions{Dir: dir})
+		So(err, ShouldBeNil)
+		l.Close()
+		So(ioutil.WriteFile(filepath.Join(dir, walCheckpointFile), []byte(`{"segment":1,"offset":4096}`), 0640), ShouldBeNil)
+
+		Convey("New entries should still be drained", func() {
+			l, err := openWAL(WALOptions{Dir: dir})
+			So(err, ShouldBeNil)
+			defer l.Close()
+			So(l.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			So(receive(collect(l, nil)).eventType, ShouldEqual, "raw")
+		})
+	})
+}
+
+func TestWALSet(t *testing.T) {
+	Convey("Given write-ahead logs per tenant", t, func() {
+		dir := t.TempDir()
+		s, err := openWALSet(WALOptions{Dir: dir})
+		So(err, ShouldBeNil)
+		closed := false
+		Reset(func() {
+			if !closed {
+				s.Close()
+			}
+		})
+
+		Convey("A tenant whose upstreams are down should not hold up others", func() {
+			So(s.Append("team-a", "raw", testMessages("raw", 1)), ShouldBeNil)
+			entries := make(chan walEntry, 100)
+			s.Start(func(tenant, eventType string, msgs []*protocol.MessageExt) error {
+				if tenant == "team-a" {
+					return errors.New("forwarder unavailable")
+				}
+				entries <- walEntry{tenant, eventType, msgs}
+				return nil
+			})
+			So(s.Append("team-b", "raw", testMessages("raw", 1)), ShouldBeNil)
+			So(s.Append("", "raw", testMessages("raw", 1)), ShouldBeNil)
+			tenants := []string{receive(entries).tenant, receive(entries).tenant}
+			So(tenants, ShouldContain, "team-b")
+			So(tenants, ShouldContain, "")
+		})
+
+		Convey("When the logs are reopened", func() {
+			So(s.Append("team-a", "raw", testMessages("raw", 1)), ShouldBeNil)
+			So(s.Append("..", "raw", testMessages("raw", 1)), ShouldBeNil)
+			s.Close()
+			closed = true
+			s, err := openWALSet(WALOptions{Dir: dir})
+			So(err, ShouldBeNil)
+			defer s.Close()
+
+			Convey("Then the logs of the tenants should be drained", func() {
+				So(segmentFiles(filepath.Join(dir, walTenantsDir, "%2E%2E")), ShouldHaveLength, 1)
+				entries := make(chan walEntry, 100)
+				s.Start(func(tenant, eventType string, msgs []*protocol.MessageExt) error {
+					entries <- walEntry{tenant, eventType, msgs}
+					return nil
+				})
+				tenants := []string{receive(entries).tenant, receive(entries).tenant}
+				So(tenants, ShouldContain, "team-a")
+				So(tenants, ShouldContain, "..")
+			})
+		})
+	})
+
+	Convey("Given a full log of one tenant", t, func() {
+		s, err := openWALSet(WALOptions{Dir: t.TempDir(), MaxBytes: 1})
+		So(err, ShouldBeNil)
+		defer s.Close()
+		So(s.Append("team-a", "raw", testMessages("raw", 1)), ShouldEqual, errWALFull)
+		s.logs["team-a"].size = 1
+
+		Convey("The endpoint should stay ready while other logs accept entries", func() {
+			So(s.Check(), ShouldBeNil)
+			s.logs[""].size = 1
+			So(s.Check(), ShouldEqual, errWALFull)
+		})
+	})
+}